@@ -0,0 +1,153 @@
+package ratelimiter
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// CompositeRateLimiter enforces several rate limits on the same keys, e.g.
+// 10 per second and 1000 per hour. A request is only allowed if every one
+// of the rate limiters allows it. The rate limiters are checked in order,
+// and the units taken by the ones before are refunded if one of them
+// rejects the request, so that a rejected request does not use up the
+// quota of the other limits.
+//
+// The key is passed to every rate limiter as is, so that their resolvers
+// and key logic see the key of the caller. Rate limiters that share a
+// repository need distinct key prefixes, see WithKeyPrefix, not to share
+// the counts of a key.
+//
+// The units are taken for a short while before they are refunded, so
+// concurrent requests may be rejected by a limit that is then refunded.
+// Putting the most restrictive rate limiter first keeps it short.
+type CompositeRateLimiter struct {
+	limiters []RateLimiter
+}
+
+// NewCompositeRateLimiter returns a new instance of composite rate limiter
+// of the given rate limiters. Example:
+//
+//   perSecond := NewTokenBucketRateLimiter(10, time.Second, 10, repo, clock)
+//   perHour := NewFixedWindowRateLimiter(1000, time.Hour, repo, clock)
+//   rateLimiter := NewCompositeRateLimiter(perSecond, perHour)
+func NewCompositeRateLimiter(limiters ...RateLimiter) *CompositeRateLimiter {
+	return &CompositeRateLimiter{
+		limiters: limiters,
+	}
+}
+
+// Allow checks a request of 1 unit of the given key against every rate
+// limiter
+func (r *CompositeRateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+// AllowN checks a request of n units of the given key against every rate
+// limiter and returns the most restrictive result. If a rate limiter
+// rejects the request or fails, the units are refunded to the rate
+// limiters that have allowed it.
+func (r *CompositeRateLimiter) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	results := make([]*Result, 0, len(r.limiters))
+	for i, limiter := range r.limiters {
+		res, err := limiter.AllowN(ctx, key, n)
+		if err != nil {
+			if refundErr := r.refund(ctx, key, n, i); refundErr != nil {
+				return nil, refundErr
+			}
+			return nil, err
+		}
+		if res.Allowed == 0 {
+			if err := r.refund(ctx, key, n, i); err != nil {
+				return nil, err
+			}
+			// the units given back are available again
+			for _, allowed := range results {
+				allowed.Allowed = 0
+				allowed.Remaining += n
+				if allowed.Remaining > allowed.Limit {
+					allowed.Remaining = allowed.Limit
+				}
+			}
+			return mergeResults(append(results, res)), nil
+		}
+		results = append(results, res)
+	}
+	return mergeResults(results), nil
+}
+
+// refund gives n units back to the first count rate limiters
+func (r *CompositeRateLimiter) refund(ctx context.Context, key string, n int, count int) error {
+	for _, limiter := range r.limiters[:count] {
+		if err := limiter.Refund(ctx, key, n); err != nil {
+			return errors.Wrap(err, "failed to refund rate limiter")
+		}
+	}
+	return nil
+}
+
+// Status returns the most restrictive state of the rate limits of the
+// given key
+func (r *CompositeRateLimiter) Status(ctx context.Context, key string) (*Result, error) {
+	results := make([]*Result, 0, len(r.limiters))
+	for _, limiter := range r.limiters {
+		res, err := limiter.Status(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return mergeResults(results), nil
+}
+
+// Reset clears the rate limit of the given key in every rate limiter. It
+// returns the first error, but still resets the rest of them.
+func (r *CompositeRateLimiter) Reset(ctx context.Context, key string) error {
+	var firstErr error
+	for _, limiter := range r.limiters {
+		if err := limiter.Reset(ctx, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Refund gives n units back to the rate limit of the given key in every
+// rate limiter. It returns the first error, but still refunds the rest of
+// them.
+func (r *CompositeRateLimiter) Refund(ctx context.Context, key string, n int) error {
+	var firstErr error
+	for _, limiter := range r.limiters {
+		if err := limiter.Refund(ctx, key, n); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// mergeResults returns the most restrictive of the given results i.e. the
+// least allowed and remaining units along with the limit of the rate
+// limiter with the least remaining units, and the longest durations
+func mergeResults(results []*Result) *Result {
+	if len(results) == 0 {
+		return &Result{}
+	}
+
+	merged := *results[0]
+	for _, res := range results[1:] {
+		if res.Allowed < merged.Allowed {
+			merged.Allowed = res.Allowed
+		}
+		if res.Remaining < merged.Remaining {
+			merged.Remaining = res.Remaining
+			merged.Limit = res.Limit
+		}
+		if res.RetryAfter > merged.RetryAfter {
+			merged.RetryAfter = res.RetryAfter
+		}
+		if res.ResetAfter > merged.ResetAfter {
+			merged.ResetAfter = res.ResetAfter
+		}
+	}
+	return &merged
+}
@@ -0,0 +1,177 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+func newTestClock() *clock.Mock {
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	return mockClock
+}
+
+func TestCompositeRateLimiter_AllowN(t *testing.T) {
+	ctx := context.Background()
+	mockClock := newTestClock()
+	repo := repository.NewInMemRepository()
+	perSecond := ratelimiter.NewFixedWindowRateLimiter(2, time.Second, repo, mockClock)
+	perMinute := ratelimiter.NewSlidingWindowLogRateLimiter(3, time.Minute, repo, mockClock)
+	r := ratelimiter.NewCompositeRateLimiter(perSecond, perMinute)
+
+	res, err := r.Allow(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 2, Remaining: 1, ResetAfter: time.Minute}, res)
+
+	res, err = r.Allow(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 2, Remaining: 0, ResetAfter: time.Minute}, res)
+
+	// rejected by the per second limit
+	res, err = r.Allow(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+	assert.Equal(t, 2, res.Limit)
+	assert.Equal(t, time.Second, res.RetryAfter)
+
+	// rejected by the per minute limit, which reports the longest wait
+	mockClock.Add(time.Second)
+	res, err = r.Allow(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+	res, err = r.Allow(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+	assert.Equal(t, 3, res.Limit)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 59*time.Second, res.RetryAfter)
+
+	// the unit taken by the per second limit has been refunded
+	res, err = perSecond.Status(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Remaining)
+}
+
+func TestCompositeRateLimiter_SharedRepository(t *testing.T) {
+	ctx := context.Background()
+	mockClock := newTestClock()
+	repo := repository.NewInMemRepository()
+	perSecond, err := ratelimiter.NewFixedWindowRateLimiterWithOptions(10, time.Second,
+		ratelimiter.WithRepository(repo), ratelimiter.WithClock(mockClock), ratelimiter.WithKeyPrefix("second:"))
+	require.NoError(t, err)
+	perMinute, err := ratelimiter.NewFixedWindowRateLimiterWithOptions(20, time.Minute,
+		ratelimiter.WithRepository(repo), ratelimiter.WithClock(mockClock), ratelimiter.WithKeyPrefix("minute:"))
+	require.NoError(t, err)
+	r := ratelimiter.NewCompositeRateLimiter(perSecond, perMinute)
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 15; j++ {
+			res, err := r.Allow(ctx, "key1")
+			require.NoError(t, err)
+			allowed += res.Allowed
+		}
+		mockClock.Add(time.Second)
+	}
+	assert.Equal(t, 20, allowed)
+
+	res, err := r.Status(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 20, res.Limit)
+}
+
+func TestCompositeRateLimiter_Resolver(t *testing.T) {
+	ctx := context.Background()
+	mockClock := newTestClock()
+	repo := repository.NewInMemRepository()
+	resolver := ratelimiter.NewStaticLimitResolver(map[string]ratelimiter.Limit{
+		"premium": {Limit: 10, Duration: time.Minute},
+	}, ratelimiter.Limit{Limit: 2, Duration: time.Minute})
+	perPlan := ratelimiter.NewFixedWindowRateLimiterWithResolver(resolver, repo, mockClock)
+	perSecond := ratelimiter.NewTokenBucketRateLimiter(100, time.Second, 100, repo, mockClock)
+	r := ratelimiter.NewCompositeRateLimiter(perPlan, perSecond)
+
+	// the resolver sees the key of the caller
+	for key, limit := range map[string]int{"premium": 10, "free": 2} {
+		allowed := 0
+		for i := 0; i < 15; i++ {
+			res, err := r.Allow(ctx, key)
+			require.NoError(t, err)
+			allowed += res.Allowed
+		}
+		assert.Equal(t, limit, allowed, key)
+	}
+}
+
+func TestCompositeRateLimiter_Error(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockClock := newTestClock()
+	mockRepo := mocks.NewMockRepository(ctrl)
+	first := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, repository.NewInMemRepository(), mockClock)
+	second := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, mockRepo, mockClock)
+	r := ratelimiter.NewCompositeRateLimiter(first, second)
+
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 2).Return(0, errRepo)
+	res, err := r.AllowN(ctx, "key1", 2)
+	assert.Error(t, err)
+	assert.Nil(t, res)
+
+	res, err = first.Status(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 5, res.Remaining)
+
+	// n that exceeds the limit of a later rate limiter is refunded too
+	small := ratelimiter.NewFixedWindowRateLimiter(1, time.Minute, repository.NewInMemRepository(), mockClock)
+	r = ratelimiter.NewCompositeRateLimiter(first, small)
+	_, err = r.AllowN(ctx, "key1", 2)
+	assert.Equal(t, ratelimiter.ErrExceedsLimit, err)
+
+	res, err = first.Status(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 5, res.Remaining)
+}
+
+func TestCompositeRateLimiter_StatusResetRefund(t *testing.T) {
+	ctx := context.Background()
+	mockClock := newTestClock()
+	repo := repository.NewInMemRepository()
+	perSecond := ratelimiter.NewTokenBucketRateLimiter(5, time.Second, 5, repo, mockClock)
+	perMinute := ratelimiter.NewFixedWindowRateLimiter(10, time.Minute, repo, mockClock)
+	r := ratelimiter.NewCompositeRateLimiter(perSecond, perMinute)
+
+	_, err := r.AllowN(ctx, "key1", 4)
+	require.NoError(t, err)
+	mockClock.Add(time.Second)
+	_, err = r.AllowN(ctx, "key1", 4)
+	require.NoError(t, err)
+
+	res, err := r.Status(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Remaining)
+	assert.Equal(t, 5, res.Limit)
+
+	require.NoError(t, r.Refund(ctx, "key1", 2))
+	res, err = perMinute.Status(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 4, res.Remaining)
+	res, err = perSecond.Status(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Remaining)
+
+	require.NoError(t, r.Reset(ctx, "key1"))
+	res, err = r.Status(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 5, res.Remaining)
+}
@@ -28,11 +28,13 @@ func main() {
 	port := viper.GetInt("PORT")
 	limit := viper.GetInt("RATE_LIMIT_COUNT")
 	duration := viper.GetDuration("RATE_LIMIT_DURATION")
+	snapshotPath := viper.GetString("SNAPSHOT_PATH")
 
 	httpServer := server.NewHTTPServer(server.Opts{
 		Port:              port,
 		RateLimitCount:    limit,
 		RateLimitDuration: duration,
+		SnapshotPath:      snapshotPath,
 	})
 
 	ctx, cancel := context.WithCancel(context.Background())
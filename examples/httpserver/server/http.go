@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/benbjohnson/clock"
@@ -23,6 +24,11 @@ type Opts struct {
 	Port              int
 	RateLimitCount    int
 	RateLimitDuration time.Duration
+
+	// SnapshotPath is the file that the rate limits are written to on
+	// shutdown and loaded from on start. The rate limits are reset on
+	// restart if it is empty.
+	SnapshotPath string
 }
 
 // NewHTTPServer instantiates a new HTTPServer object with the
@@ -37,6 +43,11 @@ func (s *HTTPServer) Start(ctx context.Context) error {
 	// init dependencies
 	inMemRepo := repository.NewInMemRepository()
 	clock := clock.New()
+	if s.opts.SnapshotPath != "" {
+		if err := s.restoreSnapshot(inMemRepo, clock.Now()); err != nil {
+			log.Println("failed to restore rate limits:", err)
+		}
+	}
 	fixedWindowLimiter := ratelimiter.NewFixedWindowRateLimiter(s.opts.RateLimitCount, s.opts.RateLimitDuration, inMemRepo, clock)
 	rateLimitMiddleware := middleware.NewRateLimiterMiddleware(fixedWindowLimiter, clock)
 
@@ -75,10 +86,49 @@ func (s *HTTPServer) Start(ctx context.Context) error {
 		log.Fatal("server shutdown failed:", err)
 	}
 
+	// checkpoint the rate limits once no more requests are served
+	if s.opts.SnapshotPath != "" {
+		if err := s.writeSnapshot(inMemRepo); err != nil {
+			log.Println("failed to snapshot rate limits:", err)
+		}
+	}
+
 	log.Printf("server exited gracefully")
 	return err
 }
 
+// restoreSnapshot loads the rate limits from the snapshot file if it
+// exists
+func (s *HTTPServer) restoreSnapshot(repo *repository.InMemRepository, now time.Time) error {
+	f, err := os.Open(s.opts.SnapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return repo.Restore(f, now, s.opts.RateLimitDuration)
+}
+
+// writeSnapshot writes the rate limits to a temporary file first, so that
+// the previous snapshot is not corrupted if the write fails
+func (s *HTTPServer) writeSnapshot(repo *repository.InMemRepository) error {
+	tmp := s.opts.SnapshotPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := repo.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.opts.SnapshotPath)
+}
+
 // handlePing is a health check endpoint
 func handlePing(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "pong")
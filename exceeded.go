@@ -0,0 +1,92 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultExceededCacheSize is the number of keys that a limiter
+// remembers to have exceeded their limit
+const defaultExceededCacheSize = 10000
+
+// exceededCache keeps track of the keys that have exceeded their limit
+// until the end of their window, so that requests of those keys can be
+// rejected without calling the repository. It is safe for concurrent use.
+//
+// The cache holds up to size keys. When it is full, expired keys are
+// purged to make room and if there is still no room, the key is simply
+// not cached. This is fine because the cache is only a short-circuit,
+// the repository still rejects the requests of keys that are not cached.
+type exceededCache struct {
+	mu   sync.Mutex
+	size int
+	keys map[string]time.Time
+
+	// the earliest expiry in keys, there is nothing to purge before it
+	nextExpiry time.Time
+}
+
+func newExceededCache(size int) *exceededCache {
+	return &exceededCache{
+		size: size,
+		keys: map[string]time.Time{},
+	}
+}
+
+// get returns the time until which the given key has exceeded its limit
+// and whether it still has at now
+func (c *exceededCache) get(key string, now time.Time) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, ok := c.keys[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !now.Before(until) {
+		delete(c.keys, key)
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// add flags the given key to have exceeded its limit until the given time
+func (c *exceededCache) add(key string, until, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.keys[key]; !ok && len(c.keys) >= c.size {
+		c.purge(now)
+		if len(c.keys) >= c.size {
+			return
+		}
+	}
+
+	c.keys[key] = until
+	if c.nextExpiry.IsZero() || until.Before(c.nextExpiry) {
+		c.nextExpiry = until
+	}
+}
+
+// remove unflags the given key
+func (c *exceededCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.keys, key)
+}
+
+// purge removes the expired keys. It must be called while holding the lock.
+func (c *exceededCache) purge(now time.Time) {
+	if now.Before(c.nextExpiry) {
+		return
+	}
+
+	c.nextExpiry = time.Time{}
+	for key, until := range c.keys {
+		if !now.Before(until) {
+			delete(c.keys, key)
+			continue
+		}
+		if c.nextExpiry.IsZero() || until.Before(c.nextExpiry) {
+			c.nextExpiry = until
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExceededCache(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	c := newExceededCache(2)
+
+	c.add("key1", t0.Add(time.Second), t0)
+	c.add("key2", t0.Add(2*time.Second), t0)
+
+	until, ok := c.get("key1", t0)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(time.Second), until)
+
+	// cache is full, key3 is not cached
+	c.add("key3", t0.Add(2*time.Second), t0)
+	_, ok = c.get("key3", t0)
+	assert.False(t, ok)
+
+	// key1 has expired, so it makes room for key3
+	c.add("key3", t0.Add(3*time.Second), t0.Add(time.Second))
+	_, ok = c.get("key1", t0.Add(time.Second))
+	assert.False(t, ok)
+	until, ok = c.get("key3", t0.Add(time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(3*time.Second), until)
+
+	// key2 expires at the end of its window
+	_, ok = c.get("key2", t0.Add(2*time.Second))
+	assert.False(t, ok)
+	assert.Len(t, c.keys, 1)
+}
@@ -0,0 +1,333 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is passed to FailSafeOpts.OnFailure when the limiter is
+// not called because its circuit breaker is open. It is also returned by
+// Reset and Refund in that case, unless Refund can give the units back to
+// the fallback rate limiter instead.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const (
+	// DefaultFailureThreshold is the default of FailSafeOpts.FailureThreshold
+	DefaultFailureThreshold = 5
+
+	// DefaultCooldown is the default of FailSafeOpts.Cooldown
+	DefaultCooldown = 10 * time.Second
+)
+
+// FailurePolicy decides the result of a request when the rate limiter
+// fails, e.g. because its repository is not reachable
+type FailurePolicy int
+
+const (
+	// FailOpen allows every request
+	FailOpen FailurePolicy = iota
+
+	// FailClosed rejects every request. RetryAfter is the rest of the
+	// cooldown of the circuit breaker if it is open, or else Cooldown, so
+	// that e.g. a Waiter does not keep calling a rate limiter that fails.
+	FailClosed
+
+	// FailFallback checks the requests against FailSafeOpts.Fallback,
+	// e.g. a rate limiter with an in-mem repository. FailClosed is applied
+	// instead if there is no Fallback, as there is nothing to check the
+	// requests against.
+	FailFallback
+)
+
+// FailSafeOpts stores the configuration options of FailSafeRateLimiter
+type FailSafeOpts struct {
+	// Policy is applied to the requests when the rate limiter fails.
+	// Defaults to FailOpen.
+	Policy FailurePolicy
+
+	// Limit is reported in the results of FailOpen and FailClosed, as the
+	// state of the rate limit is not known
+	Limit int
+
+	// Fallback is the rate limiter of FailFallback
+	Fallback RateLimiter
+
+	// FailureThreshold is the number of consecutive failures that opens
+	// the circuit breaker. Defaults to DefaultFailureThreshold.
+	FailureThreshold int
+
+	// Cooldown is how long the circuit breaker stays open before the rate
+	// limiter is tried again. Defaults to DefaultCooldown.
+	Cooldown time.Duration
+
+	// OnFailure is called with the error whenever the policy is applied
+	// to a request, e.g. to count the failures in a metric
+	OnFailure func(ctx context.Context, key string, err error)
+
+	// OnCircuitChange is called when the circuit breaker opens or closes
+	OnCircuitChange func(open bool)
+}
+
+// FailSafeRateLimiter wraps a rate limiter to apply a FailurePolicy
+// instead of returning its errors. Once the rate limiter has failed
+// FailureThreshold times in a row, a circuit breaker stops calling it for
+// Cooldown, after which a single request tries it again. Errors of
+// invalid requests such as ErrInvalidN, and errors of a ctx that is done,
+// are still returned as they are not failures of the rate limiter.
+//
+// It is safe for concurrent use as long as the wrapped rate limiters are.
+type FailSafeRateLimiter struct {
+	limiter RateLimiter
+	opts    FailSafeOpts
+	breaker *circuitBreaker
+}
+
+// NewFailSafeRateLimiter returns a new instance of fail-safe rate limiter
+// wrapping the given limiter. Example:
+//
+//   redisLimiter := NewFixedWindowRateLimiter(10, time.Minute, redisRepo, clock)
+//   localLimiter := NewFixedWindowRateLimiter(10, time.Minute, repository.NewInMemRepository(), clock)
+//   rateLimiter := NewFailSafeRateLimiter(redisLimiter, clock, FailSafeOpts{
+//       Policy:   FailFallback,
+//       Fallback: localLimiter,
+//   })
+func NewFailSafeRateLimiter(limiter RateLimiter, clock clock.Clock, opts FailSafeOpts) *FailSafeRateLimiter {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = DefaultFailureThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultCooldown
+	}
+	if opts.Policy == FailFallback && opts.Fallback == nil {
+		opts.Policy = FailClosed
+	}
+	return &FailSafeRateLimiter{
+		limiter: limiter,
+		opts:    opts,
+		breaker: &circuitBreaker{
+			clock:     clock,
+			threshold: opts.FailureThreshold,
+			cooldown:  opts.Cooldown,
+			onChange:  opts.OnCircuitChange,
+		},
+	}
+}
+
+// Allow checks a request of 1 unit of the given key
+func (r *FailSafeRateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+// AllowN checks a request of n units of the given key with the wrapped
+// rate limiter, or applies the policy if it fails
+func (r *FailSafeRateLimiter) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	res, err := r.call(ctx, func() (*Result, error) {
+		return r.limiter.AllowN(ctx, key, n)
+	})
+	if err == nil {
+		return res, nil
+	}
+	if !r.isFailure(ctx, err) {
+		return nil, err
+	}
+
+	r.onFailure(ctx, key, err)
+	switch r.opts.Policy {
+	case FailClosed:
+		return &Result{
+			Allowed:    0,
+			Limit:      r.opts.Limit,
+			Remaining:  0,
+			RetryAfter: r.breaker.retryAfter(),
+		}, nil
+	case FailFallback:
+		return r.opts.Fallback.AllowN(ctx, key, n)
+	default:
+		remaining := r.opts.Limit - n
+		if remaining < 0 {
+			remaining = 0
+		}
+		return &Result{
+			Allowed:   n,
+			Limit:     r.opts.Limit,
+			Remaining: remaining,
+		}, nil
+	}
+}
+
+// Status returns the state of the rate limit of the given key from the
+// wrapped rate limiter, or according to the policy if it fails
+func (r *FailSafeRateLimiter) Status(ctx context.Context, key string) (*Result, error) {
+	res, err := r.call(ctx, func() (*Result, error) {
+		return r.limiter.Status(ctx, key)
+	})
+	if err == nil {
+		return res, nil
+	}
+	if !r.isFailure(ctx, err) {
+		return nil, err
+	}
+
+	r.onFailure(ctx, key, err)
+	switch r.opts.Policy {
+	case FailClosed:
+		return &Result{
+			Limit:      r.opts.Limit,
+			Remaining:  0,
+			RetryAfter: r.breaker.retryAfter(),
+		}, nil
+	case FailFallback:
+		return r.opts.Fallback.Status(ctx, key)
+	default:
+		return &Result{
+			Limit:     r.opts.Limit,
+			Remaining: r.opts.Limit,
+		}, nil
+	}
+}
+
+// Reset clears the rate limit of the given key in the wrapped rate
+// limiter, and in the fallback rate limiter if there is one
+func (r *FailSafeRateLimiter) Reset(ctx context.Context, key string) error {
+	if r.opts.Fallback != nil {
+		if err := r.opts.Fallback.Reset(ctx, key); err != nil {
+			return errors.Wrap(err, "failed to reset fallback rate limiter")
+		}
+	}
+	_, err := r.call(ctx, func() (*Result, error) {
+		return nil, r.limiter.Reset(ctx, key)
+	})
+	return err
+}
+
+// Refund gives n units back to the rate limit of the given key in the
+// wrapped rate limiter. They are given back to the fallback rate limiter
+// instead if the wrapped one fails, as that is most likely where the
+// units have been taken from.
+func (r *FailSafeRateLimiter) Refund(ctx context.Context, key string, n int) error {
+	_, err := r.call(ctx, func() (*Result, error) {
+		return nil, r.limiter.Refund(ctx, key, n)
+	})
+	if err == nil || !r.isFailure(ctx, err) || r.opts.Policy != FailFallback {
+		return err
+	}
+	return r.opts.Fallback.Refund(ctx, key, n)
+}
+
+// call calls fn unless the circuit breaker is open, and records whether
+// it has failed
+func (r *FailSafeRateLimiter) call(ctx context.Context, fn func() (*Result, error)) (*Result, error) {
+	if !r.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	res, err := fn()
+	switch {
+	case err == nil:
+		r.breaker.success()
+	case r.isFailure(ctx, err):
+		r.breaker.failure()
+	default:
+		// the call has not told whether the failures are over
+		r.breaker.release()
+	}
+	return res, err
+}
+
+// isFailure returns whether err is a failure of the rate limiter rather
+// than of the request
+func (r *FailSafeRateLimiter) isFailure(ctx context.Context, err error) bool {
+	if err == ErrInvalidN || err == ErrExceedsLimit {
+		return false
+	}
+	return ctx.Err() == nil
+}
+
+func (r *FailSafeRateLimiter) onFailure(ctx context.Context, key string, err error) {
+	if r.opts.OnFailure != nil {
+		r.opts.OnFailure(ctx, key, err)
+	}
+}
+
+// circuitBreaker opens after threshold consecutive failures. Once the
+// cooldown has passed, it lets a single call through to probe whether the
+// failures are over, which closes it if it succeeds and opens it for
+// another cooldown otherwise.
+type circuitBreaker struct {
+	clock     clock.Clock
+	threshold int
+	cooldown  time.Duration
+	onChange  func(open bool)
+
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns whether a call may go through
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if b.probing || b.clock.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// retryAfter returns the duration until the next call may go through, or
+// the cooldown if it is not known, i.e. while the circuit breaker is
+// closed or being probed
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d := b.openUntil.Sub(b.clock.Now()); b.open && d > 0 {
+		return d
+	}
+	return b.cooldown
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	wasOpen := b.open
+	b.failures = 0
+	b.open = false
+	b.probing = false
+	b.mu.Unlock()
+
+	if wasOpen && b.onChange != nil {
+		b.onChange(false)
+	}
+}
+
+// release lets another call probe the circuit breaker
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	wasOpen := b.open
+	b.failures++
+	if b.open || b.failures >= b.threshold {
+		b.open = true
+		b.openUntil = b.clock.Now().Add(b.cooldown)
+	}
+	b.probing = false
+	opened := !wasOpen && b.open
+	b.mu.Unlock()
+
+	if opened && b.onChange != nil {
+		b.onChange(true)
+	}
+}
@@ -0,0 +1,245 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+var errRepo = errors.New("connection refused")
+
+func TestFailSafeRateLimiter_Policy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ratelimiter.FailurePolicy
+		expected *ratelimiter.Result
+	}{
+		{
+			name:     "fail open",
+			policy:   ratelimiter.FailOpen,
+			expected: &ratelimiter.Result{Allowed: 1, Limit: 5, Remaining: 4},
+		},
+		{
+			name:     "fail closed",
+			policy:   ratelimiter.FailClosed,
+			expected: &ratelimiter.Result{Allowed: 0, Limit: 5, Remaining: 0, RetryAfter: ratelimiter.DefaultCooldown},
+		},
+		{
+			name:     "fall back",
+			policy:   ratelimiter.FailFallback,
+			expected: &ratelimiter.Result{Allowed: 1, Limit: 5, Remaining: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			ctrl := gomock.NewController(t)
+			mockRepo := mocks.NewMockRepository(ctrl)
+			mockClock := clock.NewMock()
+			limiter := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, mockRepo, mockClock)
+			fallback := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, repository.NewInMemRepository(), mockClock)
+
+			var failures []error
+			r := ratelimiter.NewFailSafeRateLimiter(limiter, mockClock, ratelimiter.FailSafeOpts{
+				Policy:   tt.policy,
+				Limit:    5,
+				Fallback: fallback,
+				OnFailure: func(ctx context.Context, key string, err error) {
+					assert.Equal(t, "key1", key)
+					failures = append(failures, err)
+				},
+			})
+
+			mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 1).Return(0, errRepo)
+			res, err := r.Allow(ctx, "key1")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+			require.Len(t, failures, 1)
+			assert.Contains(t, failures[0].Error(), "connection refused")
+
+			// the limiter is still called once it has recovered
+			mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 1).Return(3, nil)
+			res, err = r.Allow(ctx, "key1")
+			assert.NoError(t, err)
+			assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 5, Remaining: 2}, res)
+			assert.Len(t, failures, 1)
+		})
+	}
+}
+
+func TestFailSafeRateLimiter_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	limiter := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, mockRepo, mockClock)
+
+	var changes []bool
+	var failures []error
+	r := ratelimiter.NewFailSafeRateLimiter(limiter, mockClock, ratelimiter.FailSafeOpts{
+		Policy:           ratelimiter.FailClosed,
+		Limit:            5,
+		FailureThreshold: 2,
+		Cooldown:         10 * time.Second,
+		OnFailure: func(ctx context.Context, key string, err error) {
+			failures = append(failures, err)
+		},
+		OnCircuitChange: func(open bool) {
+			changes = append(changes, open)
+		},
+	})
+
+	// the circuit breaker opens after 2 failures in a row
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 1).Return(0, errRepo).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err := r.Allow(ctx, "key1")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []bool{true}, changes)
+
+	// the repository is not called until the cooldown has passed
+	mockClock.Add(4 * time.Second)
+	res, err := r.Allow(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 5, Remaining: 0, RetryAfter: 6 * time.Second}, res)
+	assert.Equal(t, ratelimiter.ErrCircuitOpen, failures[2])
+	assert.Equal(t, ratelimiter.ErrCircuitOpen, r.Reset(ctx, "key1"))
+
+	// a failed probe opens it for another cooldown
+	mockClock.Add(6 * time.Second)
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 1).Return(0, errRepo)
+	_, err = r.Allow(ctx, "key1")
+	require.NoError(t, err)
+	res, err = r.Allow(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, res.RetryAfter)
+
+	// a successful probe closes it
+	mockClock.Add(10 * time.Second)
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 1).Return(1, nil)
+	res, err = r.Allow(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+	assert.Equal(t, []bool{true, false}, changes)
+}
+
+func TestFailSafeRateLimiter_RequestErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	limiter := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, mockRepo, mockClock)
+	r := ratelimiter.NewFailSafeRateLimiter(limiter, mockClock, ratelimiter.FailSafeOpts{
+		FailureThreshold: 1,
+		OnFailure: func(ctx context.Context, key string, err error) {
+			t.Errorf("unexpected failure: %v", err)
+		},
+	})
+
+	// invalid requests are not failures of the limiter
+	_, err := r.AllowN(context.Background(), "key1", 0)
+	assert.Equal(t, ratelimiter.ErrInvalidN, err)
+	_, err = r.AllowN(context.Background(), "key1", 6)
+	assert.Equal(t, ratelimiter.ErrExceedsLimit, err)
+
+	// neither is a ctx that is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 1).Return(0, context.Canceled)
+	_, err = r.Allow(ctx, "key1")
+	assert.Error(t, err)
+
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 1).Return(1, nil)
+	res, err := r.Allow(context.Background(), "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+}
+
+func TestFailSafeRateLimiter_StatusAndRefund(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	limiter := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, mockRepo, mockClock)
+	fallback := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, repository.NewInMemRepository(), mockClock)
+	r := ratelimiter.NewFailSafeRateLimiter(limiter, mockClock, ratelimiter.FailSafeOpts{
+		Policy:   ratelimiter.FailFallback,
+		Fallback: fallback,
+	})
+
+	// the units taken from the fallback are given back to it
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 2).Return(0, errRepo)
+	_, err := r.AllowN(ctx, "key1", 2)
+	require.NoError(t, err)
+	mockRepo.EXPECT().GetByKey(gomock.Any(), "key1", gomock.Any()).Return(0, errRepo)
+	assert.NoError(t, r.Refund(ctx, "key1", 1))
+
+	mockRepo.EXPECT().GetByKey(gomock.Any(), "key1", gomock.Any()).Return(0, errRepo)
+	res, err := r.Status(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, res.Remaining)
+}
+
+func TestFailSafeRateLimiter_NoFallback(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	limiter := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, mockRepo, mockClock)
+	r := ratelimiter.NewFailSafeRateLimiter(limiter, mockClock, ratelimiter.FailSafeOpts{
+		Policy: ratelimiter.FailFallback,
+		Limit:  5,
+	})
+
+	// fails closed as there is no fallback
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 1).Return(0, errRepo)
+	res, err := r.Allow(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 5, Remaining: 0, RetryAfter: ratelimiter.DefaultCooldown}, res)
+
+	mockRepo.EXPECT().GetByKey(gomock.Any(), "key1", gomock.Any()).Return(0, errRepo)
+	res, err = r.Status(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Limit: 5, Remaining: 0, RetryAfter: ratelimiter.DefaultCooldown}, res)
+
+	mockRepo.EXPECT().GetByKey(gomock.Any(), "key1", gomock.Any()).Return(0, errRepo)
+	assert.Error(t, r.Refund(ctx, "key1", 1))
+}
+
+func TestFailSafeRateLimiter_Waiter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	limiter := ratelimiter.NewFixedWindowRateLimiter(5, time.Minute, mockRepo, mockClock)
+	r := ratelimiter.NewFailSafeRateLimiter(limiter, mockClock, ratelimiter.FailSafeOpts{
+		Policy:   ratelimiter.FailClosed,
+		Limit:    5,
+		Cooldown: 10 * time.Second,
+	})
+	w := ratelimiter.NewWaiter(r, mockClock)
+
+	// the waiter backs off for the cooldown rather than calling the
+	// failing limiter again right away
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), "key1", gomock.Any(), 1).Return(0, errRepo).Times(1)
+	ctx := deadlineCtx{Context: context.Background(), deadline: mockClock.Now().Add(5 * time.Second)}
+	done := make(chan error)
+	go func() {
+		done <- w.Wait(ctx, "key1")
+	}()
+	select {
+	case err := <-done:
+		assert.Equal(t, ratelimiter.ErrWaitExceedsDeadline, err)
+	case <-time.After(time.Second):
+		t.Fatal("Wait kept retrying the failing limiter")
+	}
+}
@@ -10,16 +10,24 @@ import (
 )
 
 // FixedWindowRateLimiter is an implementation of RateLimiter interface
-// with a fixed window algorithm
+// with a fixed window algorithm.
+//
+// It is safe for concurrent use by multiple goroutines, e.g. from an
+// http.Handler, as long as the repository is. The only state kept by the
+// limiter itself is the set of keys that have exceeded their limit, which
+// is guarded by its own mutex.
 type FixedWindowRateLimiter struct {
-	clock    clock.Clock
-	duration time.Duration
-	limit    int
-	repo     repository.Repository
-
-	// internal state
-	hasExceeded bool
-	curWindow   time.Time
+	clock     clock.Clock
+	duration  time.Duration
+	limit     int
+	repo      repository.Repository
+	keyPrefix string
+
+	// resolves the limit of each key instead of limit and duration if set
+	resolver LimitResolver
+
+	// keys that have exceeded the limit of their current window
+	exceeded *exceededCache
 }
 
 // NewFixedWindowRateLimiter returns an instance of fixed window rate limiter.
@@ -35,25 +43,78 @@ func NewFixedWindowRateLimiter(limit int, duration time.Duration, repo repositor
 		duration: duration,
 		limit:    limit,
 		repo:     repo,
+		exceeded: newExceededCache(defaultExceededCacheSize),
+	}
+}
+
+// NewFixedWindowRateLimiterWithResolver returns an instance of fixed window
+// rate limiter that consults the given resolver for the limit and duration
+// of each key, so that keys can have different limits while sharing the
+// limiter and repository. Example:
+//
+//   resolver := NewCachedLimitResolver(planResolver, time.Minute, clock)
+//   rateLimiter := NewFixedWindowRateLimiterWithResolver(resolver, repo, clock)
+func NewFixedWindowRateLimiterWithResolver(resolver LimitResolver, repo repository.Repository, clock clock.Clock) *FixedWindowRateLimiter {
+	return &FixedWindowRateLimiter{
+		clock:    clock,
+		repo:     repo,
+		resolver: resolver,
+		exceeded: newExceededCache(defaultExceededCacheSize),
 	}
 }
 
+// NewFixedWindowRateLimiterWithOptions returns an instance of fixed window
+// rate limiter like NewFixedWindowRateLimiter, but with the clock and
+// repository given as options. It returns a *ConfigError if limit or
+// duration is not positive. Example:
+//
+//   rateLimiter, err := NewFixedWindowRateLimiterWithOptions(10, time.Minute, WithRepository(repo), WithKeyPrefix("login:"))
+func NewFixedWindowRateLimiterWithOptions(limit int, duration time.Duration, opts ...Option) (*FixedWindowRateLimiter, error) {
+	if err := validatePositive("limit", limit); err != nil {
+		return nil, err
+	}
+	if err := validateDuration(duration); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	repo, ok := o.repo.(repository.Repository)
+	if !ok {
+		return nil, o.repoError("repository.Repository")
+	}
+	r := NewFixedWindowRateLimiter(limit, duration, repo, o.clock)
+	r.keyPrefix = o.keyPrefix
+	return r, nil
+}
+
 // Allow increments the request rate of the given key for the current
 // time window and returns the result
 func (r *FixedWindowRateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
-	now := r.clock.Now()
-	window := now.Truncate(r.duration)
-	if !r.curWindow.Equal(window) {
-		r.curWindow = window
-		r.hasExceeded = false
+	return r.AllowN(ctx, key, 1)
+}
+
+// AllowN increments the request rate of the given key for the current
+// time window by n and returns the result
+func (r *FixedWindowRateLimiter) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	l, err := r.resolve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	key = r.keyPrefix + key
+	if err := validateN(n, l.Limit); err != nil {
+		return nil, err
 	}
 
-	// if it has exceeded the limit before, do not increment store
-	windowResetTime := window.Add(r.duration).Sub(now)
-	if r.hasExceeded {
+	now := r.clock.Now()
+	window := now.Truncate(l.Duration)
+	windowEnd := window.Add(l.Duration)
+
+	// if the key has exceeded the limit before, do not increment store
+	windowResetTime := windowEnd.Sub(now)
+	if _, ok := r.exceeded.get(key, now); ok {
 		return &Result{
 			Allowed:    0,
-			Limit:      r.limit,
+			Limit:      l.Limit,
 			Remaining:  0,
 			RetryAfter: windowResetTime,
 			ResetAfter: windowResetTime,
@@ -61,26 +122,201 @@ func (r *FixedWindowRateLimiter) Allow(ctx context.Context, key string) (*Result
 	}
 
 	// increment the request count in the store
-	count, err := r.repo.IncrementByKey(ctx, key, window)
+	count, err := r.repo.IncrementByKeyN(ctx, key, window, n)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to increment repository")
 	}
 
-	// if exceeds the limit for the first time, flag hasExceeded
-	if count > r.limit {
-		r.hasExceeded = true
+	if count > l.Limit {
+		// revert the increment so that smaller requests can still use the
+		// rest of the limit
+		count, err = r.repo.IncrementByKeyN(ctx, key, window, -n)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to revert repository increment")
+		}
+
+		// flag the key until the end of the window only if it is full
+		// once reverted, as concurrent requests that are being reverted
+		// push the count over the limit for a moment
+		remaining := 0
+		if count < l.Limit {
+			remaining = l.Limit - count
+		} else {
+			r.exceeded.add(key, windowEnd, now)
+		}
 		return &Result{
 			Allowed:    0,
-			Limit:      r.limit,
-			Remaining:  0,
+			Limit:      l.Limit,
+			Remaining:  remaining,
 			RetryAfter: windowResetTime,
 			ResetAfter: windowResetTime,
 		}, nil
 	}
 
 	return &Result{
-		Allowed:   1,
-		Limit:     r.limit,
-		Remaining: r.limit - count,
+		Allowed:   n,
+		Limit:     l.Limit,
+		Remaining: l.Limit - count,
 	}, nil
 }
+
+// Reset removes the request counts of the given key
+func (r *FixedWindowRateLimiter) Reset(ctx context.Context, key string) error {
+	key = r.keyPrefix + key
+	if err := r.repo.DeleteByKey(ctx, key); err != nil {
+		return errors.Wrap(err, "failed to delete repository key")
+	}
+	r.exceeded.remove(key)
+	return nil
+}
+
+// Refund decrements the request rate of the given key for the current
+// time window by n. Units can only be refunded to the current window, as
+// a window that has ended is reset anyway, and the count never goes below
+// zero. This means that refunding a request after its window has ended
+// takes back at most what has been used in the new window so far.
+//
+// Requests that are being rejected push the count over the limit for a
+// moment, so the units are refunded from the limit rather than from a
+// count that is over it.
+func (r *FixedWindowRateLimiter) Refund(ctx context.Context, key string, n int) error {
+	if n < 1 {
+		return ErrInvalidN
+	}
+	l, err := r.resolve(ctx, key)
+	if err != nil {
+		return err
+	}
+	key = r.keyPrefix + key
+
+	window := r.clock.Now().Truncate(l.Duration)
+	count, err := r.repo.GetByKey(ctx, key, window)
+	if err != nil {
+		return errors.Wrap(err, "failed to get repository count")
+	}
+	if count > l.Limit {
+		n += count - l.Limit
+	}
+	if _, err := r.repo.DecrementByKey(ctx, key, window, n); err != nil {
+		return errors.Wrap(err, "failed to decrement repository")
+	}
+	r.exceeded.remove(key)
+	return nil
+}
+
+// Reserve reserves n units of the given key in the current time window,
+// or in the next one if the current window has no room for them, in which
+// case the reservation may be used once the next window starts. The
+// reservation is not OK if neither window has room. Cancelling the
+// reservation gives the units back to its window unless the window has
+// already ended.
+func (r *FixedWindowRateLimiter) Reserve(ctx context.Context, key string, n int) (*Reservation, error) {
+	l, err := r.resolve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	key = r.keyPrefix + key
+	if err := validateN(n, l.Limit); err != nil {
+		return nil, err
+	}
+
+	now := r.clock.Now()
+	window := now.Truncate(l.Duration)
+	windows := []time.Time{window, window.Add(l.Duration)}
+	if _, ok := r.exceeded.get(key, now); ok {
+		// the current window is known to be full
+		windows = windows[1:]
+	}
+
+	for _, w := range windows {
+		count, err := r.repo.IncrementByKeyN(ctx, key, w, n)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to increment repository")
+		}
+		if count > l.Limit {
+			if _, err := r.repo.IncrementByKeyN(ctx, key, w, -n); err != nil {
+				return nil, errors.Wrap(err, "failed to revert repository increment")
+			}
+			continue
+		}
+
+		timeToAct := now
+		if w.After(window) {
+			timeToAct = w
+		}
+		reservedWindow := w
+		return &Reservation{
+			clock:     r.clock,
+			ok:        true,
+			timeToAct: timeToAct,
+			limit:     l.Limit,
+			cancel: func(ctx context.Context) error {
+				if !r.clock.Now().Before(reservedWindow.Add(l.Duration)) {
+					return nil
+				}
+				_, err := r.repo.IncrementByKeyN(ctx, key, reservedWindow, -n)
+				return errors.Wrap(err, "failed to revert repository increment")
+			},
+		}, nil
+	}
+
+	return &Reservation{
+		clock: r.clock,
+		limit: l.Limit,
+	}, nil
+}
+
+// Status returns the state of the rate limit of the given key for the
+// current time window without incrementing it
+func (r *FixedWindowRateLimiter) Status(ctx context.Context, key string) (*Result, error) {
+	l, err := r.resolve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	key = r.keyPrefix + key
+	now := r.clock.Now()
+	window := now.Truncate(l.Duration)
+	windowResetTime := window.Add(l.Duration).Sub(now)
+
+	count := l.Limit
+	if _, ok := r.exceeded.get(key, now); !ok {
+		count, err = r.repo.GetByKey(ctx, key, window)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get repository count")
+		}
+	}
+
+	// requests that are being rejected may push the count over the limit
+	res := &Result{
+		Allowed:   0,
+		Limit:     l.Limit,
+		Remaining: l.Limit - count,
+	}
+	if count > 0 {
+		res.ResetAfter = windowResetTime
+	}
+	if count >= l.Limit {
+		res.Remaining = 0
+		res.RetryAfter = windowResetTime
+	}
+	return res, nil
+}
+
+// resolve returns the limit and duration of the given key
+func (r *FixedWindowRateLimiter) resolve(ctx context.Context, key string) (Limit, error) {
+	if r.resolver == nil {
+		return Limit{Limit: r.limit, Duration: r.duration}, nil
+	}
+
+	l, err := r.resolver.Resolve(ctx, key)
+	if err != nil {
+		return Limit{}, errors.Wrap(err, "failed to resolve limit")
+	}
+	if err := validatePositive("limit", l.Limit); err != nil {
+		return Limit{}, err
+	}
+	if err := validateDuration(l.Duration); err != nil {
+		return Limit{}, err
+	}
+	return l, nil
+}
@@ -3,6 +3,8 @@ package ratelimiter_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
 	"github.com/yonasstephen/ratelimiter/repository/mocks"
 )
 
@@ -141,7 +144,7 @@ func TestAllow(t *testing.T) {
 					count:      3,
 					err:        nil,
 				},
-				// should not make any repo call because hasExceed=true
+				// should not make any repo call because the key has exceeded
 				{},
 				// should make repo call because window has changed
 				{
@@ -270,8 +273,16 @@ func TestAllow(t *testing.T) {
 
 					mockRepo.
 						EXPECT().
-						IncrementByKey(gomock.Any(), gomock.Eq("test_key"), matchesTime(expectedWindow)).
+						IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(expectedWindow), gomock.Eq(1)).
 						Return(tc.expectedRepoReturn[i].count, tc.expectedRepoReturn[i].err)
+
+					// a rejected request is reverted
+					if tc.expectedRepoReturn[i].err == nil && tc.expectedRepoReturn[i].count > tc.limit {
+						mockRepo.
+							EXPECT().
+							IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(expectedWindow), gomock.Eq(-1)).
+							Return(tc.expectedRepoReturn[i].count-1, nil)
+					}
 				}
 
 				res, err := r.Allow(context.Background(), "test_key")
@@ -288,3 +299,390 @@ func TestAllow(t *testing.T) {
 		})
 	}
 }
+
+func TestAllow_KeyIsolation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewFixedWindowRateLimiter(1, 5*time.Second, mockRepo, mockClock)
+	window := mockClock.Now()
+
+	// key1 exceeds the limit
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("key1"), matchesTime(window), gomock.Eq(1)).Return(2, nil)
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("key1"), matchesTime(window), gomock.Eq(-1)).Return(1, nil)
+	res, err := r.Allow(context.Background(), "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+
+	// key2 should still hit the repository
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("key2"), matchesTime(window), gomock.Eq(1)).Return(1, nil)
+	res, err = r.Allow(context.Background(), "key2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+
+	// key1 should not hit the repository until the next window
+	res, err = r.Allow(context.Background(), "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+
+	mockClock.Add(5 * time.Second)
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("key1"), matchesTime(window.Add(5*time.Second)), gomock.Eq(1)).Return(1, nil)
+	res, err = r.Allow(context.Background(), "key1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+}
+
+func TestAllow_ConcurrentKeyIsolation(t *testing.T) {
+	mockClock := clock.NewMock()
+	limit := 5
+	r := ratelimiter.NewFixedWindowRateLimiter(limit, time.Minute, repository.NewInMemRepository(), mockClock)
+
+	// a noisy key goes way over the limit while other keys make exactly
+	// limit requests concurrently, none of which should be rejected
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Allow(context.Background(), "noisy_key")
+			assert.NoError(t, err)
+		}()
+	}
+
+	for k := 0; k < 20; k++ {
+		key := fmt.Sprintf("key%d", k)
+		for i := 0; i < limit; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := r.Allow(context.Background(), key)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, res.Allowed, key)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// This test hammers the limiter with many goroutines over many keys and
+// multiple windows. It should be run with -race (see make test) to catch
+// unsynchronized access to the limiter state, and it checks that exactly
+// limit requests per key per window are allowed.
+func TestAllow_RaceCondition(t *testing.T) {
+	mockClock := clock.NewMock()
+	limit := 10
+	keys := 50
+	requestsPerKey := 30
+	r := ratelimiter.NewFixedWindowRateLimiter(limit, time.Minute, repository.NewInMemRepository(), mockClock)
+
+	for w := 0; w < 3; w++ {
+		var mu sync.Mutex
+		allowed := map[string]int{}
+
+		wg := &sync.WaitGroup{}
+		for k := 0; k < keys; k++ {
+			key := fmt.Sprintf("key%d", k)
+			for i := 0; i < requestsPerKey; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					res, err := r.Allow(context.Background(), key)
+					if !assert.NoError(t, err) {
+						return
+					}
+					mu.Lock()
+					allowed[key] += res.Allowed
+					mu.Unlock()
+				}()
+			}
+		}
+		wg.Wait()
+
+		require.Len(t, allowed, keys)
+		for key, count := range allowed {
+			assert.Equal(t, limit, count, "window %d %s", w, key)
+		}
+
+		// move on to the next window
+		mockClock.Add(time.Minute)
+	}
+}
+
+func TestAllowN(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewFixedWindowRateLimiter(5, 5*time.Second, mockRepo, mockClock)
+	window := mockClock.Now()
+	ctx := context.Background()
+
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(window), gomock.Eq(3)).Return(3, nil)
+	res, err := r.AllowN(ctx, "test_key", 3)
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 3, Limit: 5, Remaining: 2}, res)
+
+	// there is only room for 2, should revert the increment
+	gomock.InOrder(
+		mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(window), gomock.Eq(3)).Return(6, nil),
+		mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(window), gomock.Eq(-3)).Return(3, nil),
+	)
+	res, err = r.AllowN(ctx, "test_key", 3)
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{
+		Allowed:    0,
+		Limit:      5,
+		Remaining:  2,
+		RetryAfter: 5 * time.Second,
+		ResetAfter: 5 * time.Second,
+	}, res)
+
+	// the rest of the limit can still be used
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(window), gomock.Eq(2)).Return(5, nil)
+	res, err = r.AllowN(ctx, "test_key", 2)
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 2, Limit: 5, Remaining: 0}, res)
+
+	// n can never be allowed
+	res, err = r.AllowN(ctx, "test_key", 6)
+	assert.Nil(t, res)
+	assert.Equal(t, ratelimiter.ErrExceedsLimit, err)
+
+	res, err = r.AllowN(ctx, "test_key", 0)
+	assert.Nil(t, res)
+	assert.Equal(t, ratelimiter.ErrInvalidN, err)
+
+	// failing to revert is reported
+	gomock.InOrder(
+		mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("other_key"), matchesTime(window), gomock.Eq(2)).Return(6, nil),
+		mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("other_key"), matchesTime(window), gomock.Eq(-2)).Return(0, errors.New("unexpected repo error")),
+	)
+	res, err = r.AllowN(ctx, "other_key", 2)
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to revert repository increment: unexpected repo error")
+}
+
+func TestAllow_ConcurrentRevert(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewFixedWindowRateLimiter(5, 5*time.Second, mockRepo, mockClock)
+	window := mockClock.Now()
+	ctx := context.Background()
+
+	// the count is 3, and a concurrent request of 3 that is being reverted
+	// pushes it over the limit
+	gomock.InOrder(
+		mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(window), gomock.Eq(1)).Return(7, nil),
+		mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(window), gomock.Eq(-1)).Return(3, nil),
+	)
+	res, err := r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{
+		Allowed:    0,
+		Limit:      5,
+		Remaining:  2,
+		RetryAfter: 5 * time.Second,
+		ResetAfter: 5 * time.Second,
+	}, res)
+
+	// the key is not flagged as exceeded, as there is room once reverted
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(window), gomock.Eq(1)).Return(4, nil)
+	res, err = r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 5, Remaining: 1}, res)
+}
+
+func TestStatus(t *testing.T) {
+	mockClock := clock.NewMock()
+	mockClock.Add(2 * time.Second)
+	r := ratelimiter.NewFixedWindowRateLimiter(3, 5*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.Status(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 3, Remaining: 3}, res)
+
+	_, err = r.AllowN(ctx, "test_key", 2)
+	require.NoError(t, err)
+
+	// status should not consume the limit
+	for i := 0; i < 2; i++ {
+		res, err = r.Status(ctx, "test_key")
+		require.NoError(t, err)
+		assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 3, Remaining: 1, ResetAfter: 3 * time.Second}, res)
+	}
+
+	_, err = r.AllowN(ctx, "test_key", 1)
+	require.NoError(t, err)
+	_, err = r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	res, err = r.Status(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 3, Remaining: 0, RetryAfter: 3 * time.Second, ResetAfter: 3 * time.Second}, res)
+
+	// next window
+	mockClock.Add(3 * time.Second)
+	res, err = r.Status(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 3, Remaining: 3}, res)
+}
+
+func TestStatus_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewFixedWindowRateLimiter(3, 5*time.Second, mockRepo, mockClock)
+
+	mockRepo.EXPECT().GetByKey(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now())).Return(0, errors.New("unexpected repo error"))
+	res, err := r.Status(context.Background(), "test_key")
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to get repository count: unexpected repo error")
+}
+
+func TestRefund(t *testing.T) {
+	mockClock := clock.NewMock()
+	mockClock.Add(2 * time.Second)
+	r := ratelimiter.NewFixedWindowRateLimiter(3, 5*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.AllowN(ctx, "test_key", 3)
+	require.NoError(t, err)
+	res, err := r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+
+	// refunding should let the key make requests again even though it
+	// has exceeded the limit
+	require.NoError(t, r.Refund(ctx, "test_key", 2))
+	res, err = r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 3, Remaining: 1}, res)
+
+	// the refund after the window has ended only gives back what has been
+	// used in the new window
+	mockClock.Add(3 * time.Second)
+	_, err = r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	require.NoError(t, r.Refund(ctx, "test_key", 3))
+	res, err = r.AllowN(ctx, "test_key", 3)
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 3, Limit: 3, Remaining: 0}, res)
+
+	// nothing to refund in a window that has no request yet
+	mockClock.Add(5 * time.Second)
+	require.NoError(t, r.Refund(ctx, "test_key", 1))
+	res, err = r.Status(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Remaining)
+
+	assert.Equal(t, ratelimiter.ErrInvalidN, r.Refund(ctx, "test_key", 0))
+}
+
+func TestRefund_AfterExceeded(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewFixedWindowRateLimiter(3, 5*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.AllowN(ctx, "test_key", 3)
+	require.NoError(t, err)
+	res, err := r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+
+	// the rejected request does not use up the refunded unit
+	require.NoError(t, r.Refund(ctx, "test_key", 1))
+	res, err = r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 3, Remaining: 0}, res)
+
+	res, err = r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+}
+
+func TestReset(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewFixedWindowRateLimiter(3, 5*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.AllowN(ctx, "test_key", 3)
+	require.NoError(t, err)
+	_, err = r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+
+	require.NoError(t, r.Reset(ctx, "test_key"))
+	res, err := r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 3, Remaining: 2}, res)
+}
+
+func TestResetAndRefund_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewFixedWindowRateLimiter(3, 5*time.Second, mockRepo, mockClock)
+	ctx := context.Background()
+
+	mockRepo.EXPECT().DeleteByKey(gomock.Any(), gomock.Eq("test_key")).Return(errors.New("unexpected repo error"))
+	assert.EqualError(t, r.Reset(ctx, "test_key"), "failed to delete repository key: unexpected repo error")
+
+	mockRepo.EXPECT().GetByKey(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now())).Return(0, errors.New("unexpected repo error"))
+	assert.EqualError(t, r.Refund(ctx, "test_key", 1), "failed to get repository count: unexpected repo error")
+
+	mockRepo.EXPECT().GetByKey(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now())).Return(1, nil)
+	mockRepo.EXPECT().DecrementByKey(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now()), gomock.Eq(1)).Return(0, errors.New("unexpected repo error"))
+	assert.EqualError(t, r.Refund(ctx, "test_key", 1), "failed to decrement repository: unexpected repo error")
+}
+
+func TestAllow_LimitResolver(t *testing.T) {
+	mockClock := clock.NewMock()
+	resolver := ratelimiter.NewStaticLimitResolver(map[string]ratelimiter.Limit{
+		"premium_key": {Limit: 3, Duration: 10 * time.Second},
+	}, ratelimiter.Limit{Limit: 1, Duration: 5 * time.Second})
+	r := ratelimiter.NewFixedWindowRateLimiterWithResolver(resolver, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.Allow(ctx, "free_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 1, Remaining: 0}, res)
+	res, err = r.Allow(ctx, "free_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 1, Remaining: 0, RetryAfter: 5 * time.Second, ResetAfter: 5 * time.Second}, res)
+
+	res, err = r.AllowN(ctx, "premium_key", 3)
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 3, Limit: 3, Remaining: 0}, res)
+
+	// the keys have windows of their own duration
+	mockClock.Add(5 * time.Second)
+	res, err = r.Allow(ctx, "free_key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+	res, err = r.Status(ctx, "premium_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Limit: 3, Remaining: 0, RetryAfter: 5 * time.Second, ResetAfter: 5 * time.Second}, res)
+
+	_, err = r.AllowN(ctx, "free_key", 2)
+	assert.Equal(t, ratelimiter.ErrExceedsLimit, err)
+}
+
+func TestAllow_LimitResolverError(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewFixedWindowRateLimiterWithResolver(
+		ratelimiter.NewStaticLimitResolver(nil, ratelimiter.Limit{Limit: 0, Duration: time.Second}),
+		repository.NewInMemRepository(), mockClock)
+
+	_, err := r.Allow(context.Background(), "test_key")
+	_, ok := err.(*ratelimiter.ConfigError)
+	assert.True(t, ok, "expected *ConfigError, got %T", err)
+}
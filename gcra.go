@@ -0,0 +1,209 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+// GCRARateLimiter is an implementation of RateLimiter interface with the
+// generic cell rate algorithm. It behaves like a token bucket that holds
+// up to limit tokens and is refilled at limit/duration, but only stores
+// the theoretical arrival time (TAT) of the next request per key. Each
+// allowed request pushes the TAT forward by an emission interval of
+// duration/limit, and a request is rejected if it arrives more than
+// duration ahead of the TAT.
+type GCRARateLimiter struct {
+	clock     clock.Clock
+	duration  time.Duration
+	interval  time.Duration
+	limit     int
+	repo      repository.TimestampRepository
+	keyPrefix string
+}
+
+// NewGCRARateLimiter returns an instance of GCRA rate limiter. It takes
+// limit & duration. The rate is defined as limit/duration, where up to
+// limit requests can be made at once. Example:
+//
+//   limit := 10
+//   duration := time.Minute
+//   // this allows a request every 6 seconds, with bursts of up to
+//   // 10 requests after being idle for a minute
+//   rateLimiter := NewGCRARateLimiter(limit, duration, repo, clock)
+//
+// It panics with a *ConfigError if limit or duration is not positive, or
+// if the limit is too high for the duration.
+func NewGCRARateLimiter(limit int, duration time.Duration, repo repository.TimestampRepository, clock clock.Clock) *GCRARateLimiter {
+	if err := validateRate(limit, duration); err != nil {
+		panic(err)
+	}
+	return &GCRARateLimiter{
+		clock:    clock,
+		duration: duration,
+		interval: duration / time.Duration(limit),
+		limit:    limit,
+		repo:     repo,
+	}
+}
+
+// NewGCRARateLimiterWithOptions returns an instance of GCRA rate limiter
+// like NewGCRARateLimiter, but with the clock and repository given as
+// options. It returns a *ConfigError if limit or duration is not positive,
+// or if the limit is too high for the duration.
+func NewGCRARateLimiterWithOptions(limit int, duration time.Duration, opts ...Option) (*GCRARateLimiter, error) {
+	if err := validateRate(limit, duration); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	repo, ok := o.repo.(repository.TimestampRepository)
+	if !ok {
+		return nil, o.repoError("repository.TimestampRepository")
+	}
+	r := NewGCRARateLimiter(limit, duration, repo, o.clock)
+	r.keyPrefix = o.keyPrefix
+	return r, nil
+}
+
+// Allow pushes the TAT of the given key forward by an emission interval
+// if the request conforms to the rate and returns the result
+func (r *GCRARateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+// AllowN pushes the TAT of the given key forward by n emission intervals
+// if the requests conform to the rate and returns the result. The TAT is
+// updated with a compare-and-set, which is retried when it races with
+// another request of the same key.
+func (r *GCRARateLimiter) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	key = r.keyPrefix + key
+	if err := validateN(n, r.limit); err != nil {
+		return nil, err
+	}
+
+	for {
+		stored, err := r.repo.GetTimestamp(ctx, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get repository timestamp")
+		}
+
+		now := r.clock.Now()
+		tat := stored
+		if tat.Before(now) {
+			tat = now
+		}
+
+		newTat := tat.Add(time.Duration(n) * r.interval)
+		allowAt := newTat.Add(-r.duration)
+		if now.Before(allowAt) {
+			remaining := 0
+			if tat.Add(-r.duration).Before(now) {
+				remaining = int(now.Sub(tat.Add(-r.duration)) / r.interval)
+			}
+			return &Result{
+				Allowed:    0,
+				Limit:      r.limit,
+				Remaining:  remaining,
+				RetryAfter: allowAt.Sub(now),
+				ResetAfter: tat.Sub(now),
+			}, nil
+		}
+
+		ok, err := r.repo.CompareAndSetTimestamp(ctx, key, stored, newTat)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to set repository timestamp")
+		}
+		if !ok {
+			// the TAT has been updated by another request, try again
+			// with the latest one unless ctx is done
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return &Result{
+			Allowed:    n,
+			Limit:      r.limit,
+			Remaining:  int(now.Sub(allowAt) / r.interval),
+			ResetAfter: newTat.Sub(now),
+		}, nil
+	}
+}
+
+// Status returns the state of the rate limit of the given key based on
+// its TAT without updating it
+func (r *GCRARateLimiter) Status(ctx context.Context, key string) (*Result, error) {
+	key = r.keyPrefix + key
+	tat, err := r.repo.GetTimestamp(ctx, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get repository timestamp")
+	}
+
+	now := r.clock.Now()
+	if tat.Before(now) {
+		tat = now
+	}
+
+	res := &Result{
+		Allowed:    0,
+		Limit:      r.limit,
+		ResetAfter: tat.Sub(now),
+	}
+	if tat.Add(-r.duration).Before(now) {
+		res.Remaining = int(now.Sub(tat.Add(-r.duration)) / r.interval)
+	}
+	if allowAt := tat.Add(r.interval - r.duration); now.Before(allowAt) {
+		res.RetryAfter = allowAt.Sub(now)
+	}
+	return res, nil
+}
+
+// Reset removes the TAT of the given key
+func (r *GCRARateLimiter) Reset(ctx context.Context, key string) error {
+	key = r.keyPrefix + key
+	return errors.Wrap(r.repo.DeleteTimestamp(ctx, key), "failed to delete repository timestamp")
+}
+
+// Refund moves the TAT of the given key back by n emission intervals, but
+// not before now. Like AllowN, the TAT is updated with a compare-and-set
+// that is retried when it races with another request of the same key.
+func (r *GCRARateLimiter) Refund(ctx context.Context, key string, n int) error {
+	key = r.keyPrefix + key
+	if n < 1 {
+		return ErrInvalidN
+	}
+
+	for {
+		stored, err := r.repo.GetTimestamp(ctx, key)
+		if err != nil {
+			return errors.Wrap(err, "failed to get repository timestamp")
+		}
+
+		now := r.clock.Now()
+		if !stored.After(now) {
+			// the bucket is already full
+			return nil
+		}
+
+		newTat := stored.Add(-time.Duration(n) * r.interval)
+		if newTat.Before(now) {
+			newTat = now
+		}
+
+		ok, err := r.repo.CompareAndSetTimestamp(ctx, key, stored, newTat)
+		if err != nil {
+			return errors.Wrap(err, "failed to set repository timestamp")
+		}
+		if ok {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
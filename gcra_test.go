@@ -0,0 +1,257 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+func TestGCRAAllow(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewGCRARateLimiter(3, 3*time.Second, repository.NewInMemRepository(), mockClock)
+
+	requestIntervals := []time.Duration{
+		0,
+		0,
+		0,
+		0,
+		500 * time.Millisecond,
+		500 * time.Millisecond,
+		4 * time.Second,
+	}
+	expectedResults := []*ratelimiter.Result{
+		{
+			Allowed:    1,
+			Limit:      3,
+			Remaining:  2,
+			ResetAfter: time.Duration(1 * time.Second),
+		},
+		{
+			Allowed:    1,
+			Limit:      3,
+			Remaining:  1,
+			ResetAfter: time.Duration(2 * time.Second),
+		},
+		{
+			Allowed:    1,
+			Limit:      3,
+			Remaining:  0,
+			ResetAfter: time.Duration(3 * time.Second),
+		},
+		// burst is used up
+		{
+			Allowed:    0,
+			Limit:      3,
+			Remaining:  0,
+			RetryAfter: time.Duration(1 * time.Second),
+			ResetAfter: time.Duration(3 * time.Second),
+		},
+		{
+			Allowed:    0,
+			Limit:      3,
+			Remaining:  0,
+			RetryAfter: time.Duration(500 * time.Millisecond),
+			ResetAfter: time.Duration(2500 * time.Millisecond),
+		},
+		// an emission interval has passed
+		{
+			Allowed:    1,
+			Limit:      3,
+			Remaining:  0,
+			ResetAfter: time.Duration(3 * time.Second),
+		},
+		// TAT is in the past, burst is available again
+		{
+			Allowed:    1,
+			Limit:      3,
+			Remaining:  2,
+			ResetAfter: time.Duration(1 * time.Second),
+		},
+	}
+
+	for i := 0; i < len(expectedResults); i++ {
+		// move forward the time by specified interval
+		mockClock.Add(requestIntervals[i])
+
+		res, err := r.Allow(context.Background(), "test_key")
+		assert.NoError(t, err)
+		assert.Equal(t, expectedResults[i], res, "request %d", i)
+	}
+}
+
+func TestGCRAAllow_CompareAndSetConflict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimestampRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewGCRARateLimiter(2, 2*time.Second, mockRepo, mockClock)
+	t0 := mockClock.Now()
+
+	gomock.InOrder(
+		mockRepo.EXPECT().GetTimestamp(gomock.Any(), gomock.Eq("test_key")).Return(time.Time{}, nil),
+		// another request has moved the TAT in between
+		mockRepo.EXPECT().CompareAndSetTimestamp(gomock.Any(), gomock.Eq("test_key"), matchesTime(time.Time{}), matchesTime(t0.Add(time.Second))).Return(false, nil),
+		mockRepo.EXPECT().GetTimestamp(gomock.Any(), gomock.Eq("test_key")).Return(t0.Add(time.Second), nil),
+		mockRepo.EXPECT().CompareAndSetTimestamp(gomock.Any(), gomock.Eq("test_key"), matchesTime(t0.Add(time.Second)), matchesTime(t0.Add(2*time.Second))).Return(true, nil),
+	)
+
+	res, err := r.Allow(context.Background(), "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{
+		Allowed:    1,
+		Limit:      2,
+		Remaining:  0,
+		ResetAfter: 2 * time.Second,
+	}, res)
+}
+
+func TestGCRAAllow_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimestampRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewGCRARateLimiter(2, 2*time.Second, mockRepo, mockClock)
+
+	mockRepo.EXPECT().GetTimestamp(gomock.Any(), gomock.Eq("test_key")).Return(time.Time{}, errors.New("unexpected repo error"))
+	res, err := r.Allow(context.Background(), "test_key")
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to get repository timestamp: unexpected repo error")
+
+	mockRepo.EXPECT().GetTimestamp(gomock.Any(), gomock.Eq("test_key")).Return(time.Time{}, nil)
+	mockRepo.EXPECT().CompareAndSetTimestamp(gomock.Any(), gomock.Eq("test_key"), gomock.Any(), gomock.Any()).Return(false, errors.New("unexpected repo error"))
+	res, err = r.Allow(context.Background(), "test_key")
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to set repository timestamp: unexpected repo error")
+}
+
+func TestGCRAAllow_Concurrent(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewGCRARateLimiter(10, time.Minute, repository.NewInMemRepository(), mockClock)
+
+	var mu sync.Mutex
+	allowed := 0
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := r.Allow(context.Background(), "test_key")
+			assert.NoError(t, err)
+			mu.Lock()
+			allowed += res.Allowed
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, allowed)
+}
+
+func TestGCRAAllowN(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewGCRARateLimiter(4, 4*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.AllowN(ctx, "test_key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 3, Limit: 4, Remaining: 1, ResetAfter: 3 * time.Second}, res)
+
+	// there is only room for 1
+	res, err = r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{
+		Allowed:    0,
+		Limit:      4,
+		Remaining:  1,
+		RetryAfter: time.Second,
+		ResetAfter: 3 * time.Second,
+	}, res)
+
+	mockClock.Add(time.Second)
+	res, err = r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 2, Limit: 4, Remaining: 0, ResetAfter: 4 * time.Second}, res)
+}
+
+func TestGCRAStatus(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewGCRARateLimiter(2, 10*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+
+	_, err = r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+
+	// status should not push the TAT forward
+	for i := 0; i < 2; i++ {
+		res, err = r.Status(ctx, "test_key")
+		assert.NoError(t, err)
+		assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 0, RetryAfter: 5 * time.Second, ResetAfter: 10 * time.Second}, res)
+	}
+
+	mockClock.Add(5 * time.Second)
+	res, err = r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 1, ResetAfter: 5 * time.Second}, res)
+}
+
+func TestGCRAResetAndRefund(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewGCRARateLimiter(2, 10*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+
+	assert.NoError(t, r.Refund(ctx, "test_key", 1))
+	res, err := r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 1, ResetAfter: 5 * time.Second}, res)
+
+	// the TAT is not moved back before now
+	assert.NoError(t, r.Refund(ctx, "test_key", 5))
+	assert.NoError(t, r.Refund(ctx, "test_key", 1))
+	res, err = r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+
+	_, err = r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Reset(ctx, "test_key"))
+	res, err = r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+}
+
+func TestGCRARefund_CompareAndSetConflict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTimestampRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewGCRARateLimiter(2, 10*time.Second, mockRepo, mockClock)
+	t0 := mockClock.Now()
+
+	gomock.InOrder(
+		mockRepo.EXPECT().GetTimestamp(gomock.Any(), gomock.Eq("test_key")).Return(t0.Add(5*time.Second), nil),
+		mockRepo.EXPECT().CompareAndSetTimestamp(gomock.Any(), gomock.Eq("test_key"), matchesTime(t0.Add(5*time.Second)), matchesTime(t0)).Return(false, nil),
+		mockRepo.EXPECT().GetTimestamp(gomock.Any(), gomock.Eq("test_key")).Return(t0.Add(10*time.Second), nil),
+		mockRepo.EXPECT().CompareAndSetTimestamp(gomock.Any(), gomock.Eq("test_key"), matchesTime(t0.Add(10*time.Second)), matchesTime(t0.Add(5*time.Second))).Return(true, nil),
+	)
+	assert.NoError(t, r.Refund(context.Background(), "test_key", 1))
+}
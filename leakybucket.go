@@ -0,0 +1,221 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+// ErrQueueFull is returned by LeakyBucketRateLimiter.Wait when the queue
+// of the key has no room for the request
+var ErrQueueFull = errors.New("queue is full")
+
+// LeakyBucketRateLimiter is an implementation of RateLimiter interface
+// with a leaky bucket algorithm. Each key has a bounded queue that drains
+// requests at a constant rate. It can be used in 2 modes:
+//
+// 1. Policing - Allow rejects a request when the queue is full and lets it
+//    through right away otherwise.
+// 2. Shaping - Wait blocks the caller until the request comes out of the
+//    queue, which paces the requests to the constant outflow rate.
+type LeakyBucketRateLimiter struct {
+	clock     clock.Clock
+	interval  time.Duration
+	queueSize int
+	repo      repository.LeakyBucketRepository
+	keyPrefix string
+}
+
+// NewLeakyBucketRateLimiter returns an instance of leaky bucket rate limiter.
+// It takes rate & duration for the outflow rate, which is defined as
+// rate/duration, and queueSize for the number of requests that can be
+// queued at once. Example:
+//
+//   rate := 5
+//   duration := time.Second
+//   queueSize := 10
+//   // this lets a request through every 200ms and queues up to 10 requests
+//   rateLimiter := NewLeakyBucketRateLimiter(rate, duration, queueSize, repo, clock)
+//
+// It panics with a *ConfigError if rate or duration is not positive, or
+// if the rate is too high for the duration.
+func NewLeakyBucketRateLimiter(rate int, duration time.Duration, queueSize int, repo repository.LeakyBucketRepository, clock clock.Clock) *LeakyBucketRateLimiter {
+	if err := validateRate(rate, duration); err != nil {
+		panic(err)
+	}
+	return &LeakyBucketRateLimiter{
+		clock:     clock,
+		interval:  duration / time.Duration(rate),
+		queueSize: queueSize,
+		repo:      repo,
+	}
+}
+
+// NewLeakyBucketRateLimiterWithOptions returns an instance of leaky bucket
+// rate limiter like NewLeakyBucketRateLimiter, but with the clock and
+// repository given as options. It returns a *ConfigError if rate,
+// duration, or queueSize is not positive, or if the rate is too high for
+// the duration.
+func NewLeakyBucketRateLimiterWithOptions(rate int, duration time.Duration, queueSize int, opts ...Option) (*LeakyBucketRateLimiter, error) {
+	if err := validateRate(rate, duration); err != nil {
+		return nil, err
+	}
+	if err := validatePositive("queueSize", queueSize); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	repo, ok := o.repo.(repository.LeakyBucketRepository)
+	if !ok {
+		return nil, o.repoError("repository.LeakyBucketRepository")
+	}
+	r := NewLeakyBucketRateLimiter(rate, duration, queueSize, repo, o.clock)
+	r.keyPrefix = o.keyPrefix
+	return r, nil
+}
+
+// Allow adds the request to the queue of the given key and returns the
+// result. The request is allowed right away if it fits in the queue.
+func (r *LeakyBucketRateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+// AllowN adds n requests to the queue of the given key and returns the
+// result. The requests are allowed right away if they fit in the queue.
+func (r *LeakyBucketRateLimiter) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	key = r.keyPrefix + key
+	if err := validateN(n, r.queueSize); err != nil {
+		return nil, err
+	}
+
+	now := r.clock.Now()
+	emptyAt, ok, err := r.repo.Enqueue(ctx, key, now, r.interval, r.queueSize, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to enqueue repository")
+	}
+
+	resetAfter := emptyAt.Sub(now)
+	queued := int((resetAfter + r.interval - 1) / r.interval)
+	if !ok {
+		// the requests fit once enough of the head of the queue has drained
+		retryAfter := emptyAt.Add(-time.Duration(r.queueSize-n) * r.interval).Sub(now)
+		return &Result{
+			Allowed:    0,
+			Limit:      r.queueSize,
+			Remaining:  r.queueSize - queued,
+			RetryAfter: retryAfter,
+			ResetAfter: resetAfter,
+		}, nil
+	}
+
+	return &Result{
+		Allowed:    n,
+		Limit:      r.queueSize,
+		Remaining:  r.queueSize - queued,
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+// Status returns the state of the queue of the given key without adding
+// a request to it
+func (r *LeakyBucketRateLimiter) Status(ctx context.Context, key string) (*Result, error) {
+	key = r.keyPrefix + key
+	now := r.clock.Now()
+	emptyAt, err := r.repo.GetEmptyAt(ctx, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get repository queue")
+	}
+	if emptyAt.Before(now) {
+		emptyAt = now
+	}
+
+	resetAfter := emptyAt.Sub(now)
+	queued := int((resetAfter + r.interval - 1) / r.interval)
+	res := &Result{
+		Allowed:    0,
+		Limit:      r.queueSize,
+		Remaining:  r.queueSize - queued,
+		ResetAfter: resetAfter,
+	}
+
+	// a request fits once the queue has drained to queueSize-1 requests
+	if retryAfter := emptyAt.Add(-time.Duration(r.queueSize-1) * r.interval).Sub(now); retryAfter > 0 {
+		res.RetryAfter = retryAfter
+	}
+	return res, nil
+}
+
+// Reset removes the queue of the given key
+func (r *LeakyBucketRateLimiter) Reset(ctx context.Context, key string) error {
+	key = r.keyPrefix + key
+	return errors.Wrap(r.repo.DeleteQueue(ctx, key), "failed to delete repository queue")
+}
+
+// Refund removes n requests from the queue of the given key, making room
+// for new ones. Requests that have already drained cannot be refunded.
+func (r *LeakyBucketRateLimiter) Refund(ctx context.Context, key string, n int) error {
+	key = r.keyPrefix + key
+	if n < 1 {
+		return ErrInvalidN
+	}
+
+	_, err := r.repo.Dequeue(ctx, key, r.clock.Now(), r.interval, n)
+	return errors.Wrap(err, "failed to dequeue repository")
+}
+
+// Wait adds the request to the queue of the given key and blocks until
+// the request comes out of the queue. It returns ErrQueueFull if the
+// queue is full, ErrWaitExceedsDeadline right away if the request would
+// not come out of the queue before the deadline of ctx, or ctx.Err() if
+// ctx is done while waiting, in which case the request gives its place in
+// the queue back.
+func (r *LeakyBucketRateLimiter) Wait(ctx context.Context, key string) error {
+	key = r.keyPrefix + key
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	if deadline, ok := ctx.Deadline(); ok {
+		// the request would come out of the queue once the requests ahead
+		// of it have drained
+		emptyAt, err := r.repo.GetEmptyAt(ctx, key)
+		if err != nil {
+			return errors.Wrap(err, "failed to get repository queue")
+		}
+		if emptyAt.After(deadline) {
+			return ErrWaitExceedsDeadline
+		}
+	}
+
+	emptyAt, ok, err := r.repo.Enqueue(ctx, key, now, r.interval, r.queueSize, 1)
+	if err != nil {
+		return errors.Wrap(err, "failed to enqueue repository")
+	}
+	if !ok {
+		return ErrQueueFull
+	}
+
+	// the request is the last one in the queue, so it comes out of the
+	// queue one interval before the queue is empty
+	delay := emptyAt.Add(-r.interval).Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := r.clock.Timer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// give the place in the queue back with a context that is not done
+		if _, err := r.repo.Dequeue(context.Background(), key, r.clock.Now(), r.interval, 1); err != nil {
+			return errors.Wrap(err, "failed to dequeue repository")
+		}
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,253 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+func TestLeakyBucketAllow(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewLeakyBucketRateLimiter(5, time.Second, 2, repository.NewInMemRepository(), mockClock)
+
+	requestIntervals := []time.Duration{
+		0,
+		0,
+		0,
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+		800 * time.Millisecond,
+	}
+	expectedResults := []*ratelimiter.Result{
+		{
+			Allowed:    1,
+			Limit:      2,
+			Remaining:  1,
+			ResetAfter: time.Duration(200 * time.Millisecond),
+		},
+		{
+			Allowed:    1,
+			Limit:      2,
+			Remaining:  0,
+			ResetAfter: time.Duration(400 * time.Millisecond),
+		},
+		// queue is full
+		{
+			Allowed:    0,
+			Limit:      2,
+			Remaining:  0,
+			RetryAfter: time.Duration(200 * time.Millisecond),
+			ResetAfter: time.Duration(400 * time.Millisecond),
+		},
+		{
+			Allowed:    0,
+			Limit:      2,
+			Remaining:  0,
+			RetryAfter: time.Duration(100 * time.Millisecond),
+			ResetAfter: time.Duration(300 * time.Millisecond),
+		},
+		// a request has drained out of the queue
+		{
+			Allowed:    1,
+			Limit:      2,
+			Remaining:  0,
+			ResetAfter: time.Duration(400 * time.Millisecond),
+		},
+		// queue is empty
+		{
+			Allowed:    1,
+			Limit:      2,
+			Remaining:  1,
+			ResetAfter: time.Duration(200 * time.Millisecond),
+		},
+	}
+
+	for i := 0; i < len(expectedResults); i++ {
+		// move forward the time by specified interval
+		mockClock.Add(requestIntervals[i])
+
+		res, err := r.Allow(context.Background(), "test_key")
+		assert.NoError(t, err)
+		assert.Equal(t, expectedResults[i], res, "request %d", i)
+	}
+}
+
+func TestLeakyBucketAllow_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockLeakyBucketRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewLeakyBucketRateLimiter(5, time.Second, 2, mockRepo, mockClock)
+
+	mockRepo.
+		EXPECT().
+		Enqueue(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now()), gomock.Eq(200*time.Millisecond), gomock.Eq(2), gomock.Eq(1)).
+		Return(time.Time{}, false, errors.New("unexpected repo error")).
+		Times(2)
+
+	res, err := r.Allow(context.Background(), "test_key")
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to enqueue repository: unexpected repo error")
+
+	err = r.Wait(context.Background(), "test_key")
+	assert.EqualError(t, err, "failed to enqueue repository: unexpected repo error")
+}
+
+func TestLeakyBucketWait(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewLeakyBucketRateLimiter(5, time.Second, 2, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	// queue is empty, should not block
+	require.NoError(t, r.Wait(ctx, "test_key"))
+
+	// should block until the first request has drained
+	done := make(chan error)
+	go func() {
+		done <- r.Wait(ctx, "test_key")
+	}()
+
+	// wait for the request to be queued behind the first one
+	assert.Eventually(t, func() bool {
+		res, err := r.Status(ctx, "test_key")
+		return err == nil && res.Remaining == 0
+	}, time.Second, time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("Wait returned before its turn")
+	default:
+	}
+
+	// the clock is moved until the goroutine has started waiting on it
+	var err error
+	assert.Eventually(t, func() bool {
+		mockClock.Add(200 * time.Millisecond)
+		select {
+		case err = <-done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, err)
+
+	// cancelled while waiting, the request gives its place back
+	require.NoError(t, r.Wait(ctx, "other_key"))
+	cancelCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		done <- r.Wait(cancelCtx, "other_key")
+	}()
+	assert.Eventually(t, func() bool {
+		res, err := r.Status(ctx, "other_key")
+		return err == nil && res.Remaining == 0
+	}, time.Second, time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return on cancellation")
+	}
+
+	res, err := r.Status(ctx, "other_key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Remaining)
+
+	// the request would come out of the queue after the deadline, so it
+	// is not added to the queue
+	deadline := deadlineCtx{Context: ctx, deadline: mockClock.Now().Add(100 * time.Millisecond)}
+	assert.Equal(t, ratelimiter.ErrWaitExceedsDeadline, r.Wait(deadline, "other_key"))
+	res, err = r.Status(ctx, "other_key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Remaining)
+}
+
+func TestLeakyBucketAllowN(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewLeakyBucketRateLimiter(5, time.Second, 5, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.AllowN(ctx, "test_key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 3, Limit: 5, Remaining: 2, ResetAfter: 600 * time.Millisecond}, res)
+
+	// 1 request has to drain to make room
+	res, err = r.AllowN(ctx, "test_key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{
+		Allowed:    0,
+		Limit:      5,
+		Remaining:  2,
+		RetryAfter: 200 * time.Millisecond,
+		ResetAfter: 600 * time.Millisecond,
+	}, res)
+
+	mockClock.Add(200 * time.Millisecond)
+	res, err = r.AllowN(ctx, "test_key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 3, Limit: 5, Remaining: 0, ResetAfter: time.Second}, res)
+}
+
+func TestLeakyBucketStatus(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewLeakyBucketRateLimiter(1, time.Second, 2, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.Status(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+
+	_, err = r.AllowN(ctx, "test_key", 2)
+	require.NoError(t, err)
+
+	// status should not add to the queue
+	for i := 0; i < 2; i++ {
+		res, err = r.Status(ctx, "test_key")
+		require.NoError(t, err)
+		assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 0, RetryAfter: time.Second, ResetAfter: 2 * time.Second}, res)
+	}
+
+	mockClock.Add(1500 * time.Millisecond)
+	res, err = r.Status(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 1, ResetAfter: 500 * time.Millisecond}, res)
+}
+
+func TestLeakyBucketResetAndRefund(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewLeakyBucketRateLimiter(1, time.Second, 2, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.AllowN(ctx, "test_key", 2)
+	require.NoError(t, err)
+
+	require.NoError(t, r.Refund(ctx, "test_key", 1))
+	res, err := r.Status(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 1, ResetAfter: time.Second}, res)
+
+	// requests that have drained cannot be refunded
+	mockClock.Add(500 * time.Millisecond)
+	require.NoError(t, r.Refund(ctx, "test_key", 5))
+	res, err = r.Status(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+
+	_, err = r.AllowN(ctx, "test_key", 2)
+	require.NoError(t, err)
+	require.NoError(t, r.Reset(ctx, "test_key"))
+	res, err = r.Status(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+}
@@ -0,0 +1,121 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+// ConfigError is returned by the constructors that take options when the
+// configuration of a rate limiter is invalid
+type ConfigError struct {
+	// Field is the name of the invalid argument or option
+	Field string
+
+	// Value is the invalid value
+	Value interface{}
+
+	// Reason tells what is wrong with the value
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s %v: %s", e.Field, e.Value, e.Reason)
+}
+
+// Option configures a rate limiter that is created by one of the
+// constructors that take options, e.g. NewFixedWindowRateLimiterWithOptions
+type Option func(*options)
+
+type options struct {
+	clock     clock.Clock
+	repo      interface{}
+	keyPrefix string
+}
+
+// WithClock sets the clock of the rate limiter. Defaults to the system
+// clock.
+func WithClock(clock clock.Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithRepository sets the repository of the rate limiter, which has to
+// implement the repository interface of its algorithm, e.g.
+// repository.TokenBucketRepository for the token bucket. Defaults to a new
+// repository.InMemRepository.
+func WithRepository(repo interface{}) Option {
+	return func(o *options) {
+		o.repo = repo
+	}
+}
+
+// WithKeyPrefix prepends prefix to every key of the rate limiter, so that
+// multiple rate limiters can share a repository without sharing the
+// limits of the same key
+func WithKeyPrefix(prefix string) Option {
+	return func(o *options) {
+		o.keyPrefix = prefix
+	}
+}
+
+// newOptions applies opts on top of the defaults
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.clock == nil {
+		o.clock = clock.New()
+	}
+	if o.repo == nil {
+		o.repo = repository.NewInMemRepository()
+	}
+	return o
+}
+
+// repoError returns the error of a repository that does not implement the
+// interface of an algorithm
+func (o *options) repoError(iface string) error {
+	return &ConfigError{
+		Field:  "repository",
+		Value:  fmt.Sprintf("%T", o.repo),
+		Reason: "does not implement " + iface,
+	}
+}
+
+// validatePositive returns an error if value of the given field is not
+// positive
+func validatePositive(field string, value int) error {
+	if value <= 0 {
+		return &ConfigError{Field: field, Value: value, Reason: "must be positive"}
+	}
+	return nil
+}
+
+// validateDuration returns an error if duration is not positive
+func validateDuration(duration time.Duration) error {
+	if duration <= 0 {
+		return &ConfigError{Field: "duration", Value: duration, Reason: "must be positive"}
+	}
+	return nil
+}
+
+// validateRate returns an error if rate per duration does not leave an
+// interval of at least a nanosecond between requests
+func validateRate(rate int, duration time.Duration) error {
+	if err := validatePositive("rate", rate); err != nil {
+		return err
+	}
+	if err := validateDuration(duration); err != nil {
+		return err
+	}
+	if duration/time.Duration(rate) <= 0 {
+		return &ConfigError{Field: "rate", Value: rate, Reason: fmt.Sprintf("is too high for a duration of %v", duration)}
+	}
+	return nil
+}
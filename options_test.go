@@ -0,0 +1,232 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+func TestWithOptions_Defaults(t *testing.T) {
+	constructors := map[string]func() (ratelimiter.RateLimiter, error){
+		"fixed window": func() (ratelimiter.RateLimiter, error) {
+			return ratelimiter.NewFixedWindowRateLimiterWithOptions(2, time.Minute)
+		},
+		"sliding window log": func() (ratelimiter.RateLimiter, error) {
+			return ratelimiter.NewSlidingWindowLogRateLimiterWithOptions(2, time.Minute)
+		},
+		"sliding window counter": func() (ratelimiter.RateLimiter, error) {
+			return ratelimiter.NewSlidingWindowCounterRateLimiterWithOptions(2, time.Minute)
+		},
+		"token bucket": func() (ratelimiter.RateLimiter, error) {
+			return ratelimiter.NewTokenBucketRateLimiterWithOptions(2, time.Minute, 2)
+		},
+		"leaky bucket": func() (ratelimiter.RateLimiter, error) {
+			return ratelimiter.NewLeakyBucketRateLimiterWithOptions(2, time.Minute, 2)
+		},
+		"gcra": func() (ratelimiter.RateLimiter, error) {
+			return ratelimiter.NewGCRARateLimiterWithOptions(2, time.Minute)
+		},
+	}
+
+	for name, newLimiter := range constructors {
+		t.Run(name, func(t *testing.T) {
+			rl, err := newLimiter()
+			require.NoError(t, err)
+
+			res, err := rl.Allow(context.Background(), "key")
+			require.NoError(t, err)
+			assert.Equal(t, 1, res.Allowed)
+		})
+	}
+}
+
+func TestWithOptions_ConfigError(t *testing.T) {
+	tests := map[string]struct {
+		newLimiter func() error
+		field      string
+	}{
+		"zero limit": {
+			newLimiter: func() error {
+				_, err := ratelimiter.NewFixedWindowRateLimiterWithOptions(0, time.Minute)
+				return err
+			},
+			field: "limit",
+		},
+		"negative limit": {
+			newLimiter: func() error {
+				_, err := ratelimiter.NewSlidingWindowLogRateLimiterWithOptions(-1, time.Minute)
+				return err
+			},
+			field: "limit",
+		},
+		"zero duration": {
+			newLimiter: func() error {
+				_, err := ratelimiter.NewSlidingWindowCounterRateLimiterWithOptions(1, 0)
+				return err
+			},
+			field: "duration",
+		},
+		"negative duration": {
+			newLimiter: func() error {
+				_, err := ratelimiter.NewGCRARateLimiterWithOptions(1, -time.Second)
+				return err
+			},
+			field: "duration",
+		},
+		"zero rate": {
+			newLimiter: func() error {
+				_, err := ratelimiter.NewTokenBucketRateLimiterWithOptions(0, time.Minute, 1)
+				return err
+			},
+			field: "rate",
+		},
+		"rate too high for duration": {
+			newLimiter: func() error {
+				_, err := ratelimiter.NewLeakyBucketRateLimiterWithOptions(10, time.Nanosecond, 1)
+				return err
+			},
+			field: "rate",
+		},
+		"zero burst": {
+			newLimiter: func() error {
+				_, err := ratelimiter.NewTokenBucketRateLimiterWithOptions(1, time.Minute, 0)
+				return err
+			},
+			field: "burst",
+		},
+		"negative queue size": {
+			newLimiter: func() error {
+				_, err := ratelimiter.NewLeakyBucketRateLimiterWithOptions(1, time.Minute, -1)
+				return err
+			},
+			field: "queueSize",
+		},
+		"repository of another algorithm": {
+			newLimiter: func() error {
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				_, err := ratelimiter.NewTokenBucketRateLimiterWithOptions(1, time.Minute, 1,
+					ratelimiter.WithRepository(mocks.NewMockRepository(ctrl)))
+				return err
+			},
+			field: "repository",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.newLimiter()
+			require.Error(t, err)
+
+			cfgErr, ok := err.(*ratelimiter.ConfigError)
+			require.True(t, ok, "expected *ConfigError, got %T", err)
+			assert.Equal(t, tt.field, cfgErr.Field)
+		})
+	}
+}
+
+func TestConstructors_InvalidRatePanics(t *testing.T) {
+	repo := repository.NewInMemRepository()
+	mockClock := clock.NewMock()
+	tests := map[string]struct {
+		newLimiter func()
+		err        string
+	}{
+		"token bucket zero rate": {
+			newLimiter: func() { ratelimiter.NewTokenBucketRateLimiter(0, time.Second, 1, repo, mockClock) },
+			err:        "invalid rate 0: must be positive",
+		},
+		"token bucket rate too high": {
+			newLimiter: func() { ratelimiter.NewTokenBucketRateLimiter(10, time.Nanosecond, 1, repo, mockClock) },
+			err:        "invalid rate 10: is too high for a duration of 1ns",
+		},
+		"leaky bucket negative rate": {
+			newLimiter: func() { ratelimiter.NewLeakyBucketRateLimiter(-1, time.Second, 1, repo, mockClock) },
+			err:        "invalid rate -1: must be positive",
+		},
+		"leaky bucket zero duration": {
+			newLimiter: func() { ratelimiter.NewLeakyBucketRateLimiter(1, 0, 1, repo, mockClock) },
+			err:        "invalid duration 0s: must be positive",
+		},
+		"gcra zero limit": {
+			newLimiter: func() { ratelimiter.NewGCRARateLimiter(0, time.Second, repo, mockClock) },
+			err:        "invalid rate 0: must be positive",
+		},
+		"gcra limit too high": {
+			newLimiter: func() { ratelimiter.NewGCRARateLimiter(10, time.Nanosecond, repo, mockClock) },
+			err:        "invalid rate 10: is too high for a duration of 1ns",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.PanicsWithError(t, tt.err, tt.newLimiter)
+		})
+	}
+}
+
+func TestWithKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewInMemRepository()
+
+	login, err := ratelimiter.NewFixedWindowRateLimiterWithOptions(1, time.Minute,
+		ratelimiter.WithRepository(repo), ratelimiter.WithKeyPrefix("login:"))
+	require.NoError(t, err)
+	signup, err := ratelimiter.NewFixedWindowRateLimiterWithOptions(1, time.Minute,
+		ratelimiter.WithRepository(repo), ratelimiter.WithKeyPrefix("signup:"))
+	require.NoError(t, err)
+
+	res, err := login.Allow(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+
+	// the same key of another limiter has its own limit
+	res, err = signup.Allow(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+
+	res, err = login.Allow(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+
+	require.NoError(t, login.Reset(ctx, "user"))
+	res, err = login.Allow(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+
+	res, err = signup.Status(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Remaining)
+}
+
+func TestWithClock(t *testing.T) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	rl, err := ratelimiter.NewGCRARateLimiterWithOptions(1, time.Minute, ratelimiter.WithClock(mockClock))
+	require.NoError(t, err)
+
+	res, err := rl.Allow(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+
+	res, err = rl.Allow(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+	assert.Equal(t, time.Minute, res.RetryAfter)
+
+	mockClock.Add(time.Minute)
+	res, err = rl.Allow(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+}
@@ -3,6 +3,17 @@ package ratelimiter
 import (
 	"context"
 	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidN is returned when the number of units requested is not positive
+	ErrInvalidN = errors.New("n must be positive")
+
+	// ErrExceedsLimit is returned when the number of units requested is
+	// more than the limit, hence the request can never be allowed
+	ErrExceedsLimit = errors.New("n exceeds the limit")
 )
 
 // RateLimiter is the interface of a rate limit module
@@ -10,11 +21,29 @@ type RateLimiter interface {
 	// Allow increments the rate of the request for a given key and returns
 	// information about the result of the request.
 	Allow(ctx context.Context, key string) (*Result, error)
+
+	// AllowN is like Allow but the request costs n units instead of 1.
+	// Either all n units are granted or none of them are.
+	AllowN(ctx context.Context, key string, n int) (*Result, error)
+
+	// Status returns information about the current state of the rate
+	// limit for a given key without consuming any of it. Allowed is always
+	// zero and RetryAfter is the duration until a request of 1 unit would
+	// be allowed.
+	Status(ctx context.Context, key string) (*Result, error)
+
+	// Reset clears the rate limit of the given key as if it has never
+	// made a request.
+	Reset(ctx context.Context, key string) error
+
+	// Refund gives n units back to the rate limit of the given key, e.g.
+	// when an allowed request has failed for reasons on the server side.
+	Refund(ctx context.Context, key string, n int) error
 }
 
 // Result embodies information about the current state of the rate limit
 type Result struct {
-	// Allowed is the number of requests that are allowed at time.Now().
+	// Allowed is the number of units that are allowed at time.Now().
 	// Zero value means that the request is not allowed i.e. has exceeded
 	// the rate limit threshold
 	Allowed int
@@ -35,3 +64,14 @@ type Result struct {
 	// the count. You can also think of this as the time when Limit == Remaining.
 	ResetAfter time.Duration
 }
+
+// validateN returns an error if n units can never be allowed by limit
+func validateN(n, limit int) error {
+	if n < 1 {
+		return ErrInvalidN
+	}
+	if n > limit {
+		return ErrExceedsLimit
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+// Package bolt provides a repository implementation backed by bbolt, an
+// embedded key value store, so that the rate limits of a single node
+// deployment survive restarts without running an external service.
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// DefaultExpiration is the default of Opts.Expiration
+const DefaultExpiration = time.Hour
+
+var (
+	// countersBucket holds a nested bucket per key, which maps the start
+	// of every window to its request count
+	countersBucket = []byte("ratelimit_counters")
+
+	// expiryBucket indexes the windows by when they expire, so that Purge
+	// does not have to scan every key
+	expiryBucket = []byte("ratelimit_expiry")
+)
+
+// Opts stores the configuration options of Repository
+type Opts struct {
+	// Expiration is how long a window stays in the database after it
+	// starts, before Purge removes it. Defaults to DefaultExpiration.
+	Expiration time.Duration
+
+	// OnPurgeError receives the errors of the purges run by RunPurge,
+	// which does not stop on them, e.g. to log them.
+	OnPurgeError func(err error)
+}
+
+// Repository is repository implementation with bbolt. Every update runs
+// in a read-write transaction of bbolt, which only allows one writer at a
+// time, so the counts stay correct when they are updated concurrently.
+//
+// bbolt locks the database file, so it can only be used by one process.
+// Use a repository that is backed by an external service such as
+// repository/redis for the rate limits to be shared by multiple instances.
+type Repository struct {
+	db           *bbolt.DB
+	expiration   time.Duration
+	onPurgeError func(err error)
+}
+
+// NewRepository returns a new instance of bbolt repository. It takes a
+// database that has been opened by the caller, and creates the buckets
+// of the repository if they do not exist yet. Example:
+//
+//   db, err := bbolt.Open("ratelimit.db", 0600, &bbolt.Options{Timeout: time.Second})
+//   repo, err := bolt.NewRepository(db, bolt.Opts{Expiration: time.Minute})
+//   go repo.RunPurge(ctx, time.Minute, clock.New())
+func NewRepository(db *bbolt.DB, opts Opts) (*Repository, error) {
+	expiration := opts.Expiration
+	if expiration <= 0 {
+		expiration = DefaultExpiration
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(countersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(expiryBucket)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create bolt buckets")
+	}
+
+	return &Repository{
+		db:           db,
+		expiration:   expiration,
+		onPurgeError: opts.OnPurgeError,
+	}, nil
+}
+
+// IncrementByKey increases the request count for the given key and
+// window by 1
+func (r *Repository) IncrementByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	return r.IncrementByKeyN(ctx, key, window, 1)
+}
+
+// IncrementByKeyN increases the request count for the given key and
+// window by n. A window is added to the expiry index when it is created.
+func (r *Repository) IncrementByKeyN(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	var count int
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(countersBucket).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		w := encodeTime(window)
+		v := b.Get(w)
+		if v == nil {
+			expireAt := window.Add(r.expiration)
+			if err := tx.Bucket(expiryBucket).Put(expiryKey(expireAt, window, key), nil); err != nil {
+				return err
+			}
+		}
+
+		count = decodeCount(v) + n
+		if count < 0 {
+			count = 0
+		}
+		return b.Put(w, encodeCount(count))
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to increment bolt count")
+	}
+	return count, nil
+}
+
+// GetByKey returns the request count for the given key and window
+func (r *Repository) GetByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	var count int
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket(countersBucket).Bucket([]byte(key)); b != nil {
+			count = decodeCount(b.Get(encodeTime(window)))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get bolt count")
+	}
+	return count, nil
+}
+
+// DecrementByKey decreases the request count for the given key and
+// window by n, but not below zero. It does nothing if the window does not
+// exist.
+func (r *Repository) DecrementByKey(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	var count int
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(countersBucket).Bucket([]byte(key))
+		if b == nil {
+			return nil
+		}
+		w := encodeTime(window)
+		v := b.Get(w)
+		if v == nil {
+			return nil
+		}
+
+		count = decodeCount(v) - n
+		if count < 0 {
+			count = 0
+		}
+		return b.Put(w, encodeCount(count))
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to decrement bolt count")
+	}
+	return count, nil
+}
+
+// DeleteByKey removes the request counts of every window of the given
+// key. Their entries of the expiry index are left for Purge to remove.
+func (r *Repository) DeleteByKey(ctx context.Context, key string) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		err := tx.Bucket(countersBucket).DeleteBucket([]byte(key))
+		if err == bbolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+	return errors.Wrap(err, "failed to delete bolt counts")
+}
+
+// Purge removes the request counts that have expired at now and returns
+// how many of them have been removed. A key is removed along with its
+// last window.
+func (r *Repository) Purge(ctx context.Context, now time.Time) (int, error) {
+	var purged int
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		counters := tx.Bucket(countersBucket)
+		c := tx.Bucket(expiryBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.First() {
+			expireAt, window, key := decodeExpiryKey(k)
+			if expireAt.After(now) {
+				return nil
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+
+			b := counters.Bucket(key)
+			if b == nil || b.Get(window) == nil {
+				continue
+			}
+			if err := b.Delete(window); err != nil {
+				return err
+			}
+			purged++
+
+			if k, _ := b.Cursor().First(); k == nil {
+				if err := counters.DeleteBucket(key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge bolt counts")
+	}
+	return purged, nil
+}
+
+// RunPurge calls Purge every interval until ctx is done, and returns
+// ctx.Err(). A failed purge is reported to Opts.OnPurgeError and tried
+// again on the next interval. It is meant to be run in its own goroutine.
+func (r *Repository) RunPurge(ctx context.Context, interval time.Duration, clock clock.Clock) error {
+	ticker := clock.Ticker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			if _, err := r.Purge(ctx, now); err != nil && ctx.Err() == nil && r.onPurgeError != nil {
+				r.onPurgeError(err)
+			}
+		}
+	}
+}
+
+// encodeTime encodes t as big endian Unix nanoseconds, so that the keys
+// of bbolt are sorted by time
+func encodeTime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+func encodeCount(count int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(count))
+	return b
+}
+
+// decodeCount returns 0 for a window that does not exist
+func decodeCount(v []byte) int {
+	if v == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+// expiryKey returns the key of the expiry index, which starts with the
+// expiration followed by the window and the key
+func expiryKey(expireAt, window time.Time, key string) []byte {
+	k := make([]byte, 0, 16+len(key))
+	k = append(k, encodeTime(expireAt)...)
+	k = append(k, encodeTime(window)...)
+	return append(k, key...)
+}
+
+// decodeExpiryKey is the reverse of expiryKey, but returns the window as
+// it is encoded in the counters bucket
+func decodeExpiryKey(k []byte) (time.Time, []byte, []byte) {
+	expireAt := time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+	window := append([]byte{}, k[8:16]...)
+	key := append([]byte{}, k[16:]...)
+	return expireAt, window, key
+}
@@ -0,0 +1,219 @@
+package bolt_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/bolt"
+	"github.com/yonasstephen/ratelimiter/repository/repositorytest"
+)
+
+func openDB(t *testing.T, path string) *bbolt.DB {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+// newTestRepository returns a repository backed by a database in a
+// temporary file, along with the path of the file
+func newTestRepository(t *testing.T, opts bolt.Opts) (*bolt.Repository, *bbolt.DB, string) {
+	path := filepath.Join(t.TempDir(), "ratelimit.db")
+	db := openDB(t, path)
+	repo, err := bolt.NewRepository(db, opts)
+	require.NoError(t, err)
+	return repo, db, path
+}
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		repo, _, _ := newTestRepository(t, bolt.Opts{})
+		return repo
+	})
+}
+
+func TestRepository_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, db, path := newTestRepository(t, bolt.Opts{})
+
+	_, err := repo.IncrementByKeyN(ctx, "key1", t0, 3)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	repo, err = bolt.NewRepository(openDB(t, path), bolt.Opts{})
+	require.NoError(t, err)
+	count, err := repo.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	t1 := t0.Add(time.Minute)
+	repo, db, _ := newTestRepository(t, bolt.Opts{Expiration: time.Minute})
+
+	_, err := repo.IncrementByKey(ctx, "key1", t0)
+	require.NoError(t, err)
+	_, err = repo.IncrementByKey(ctx, "key1", t1)
+	require.NoError(t, err)
+	_, err = repo.IncrementByKey(ctx, "key2", t0)
+	require.NoError(t, err)
+
+	n, err := repo.Purge(ctx, t1.Add(-time.Nanosecond))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// the windows of t0 expire at t1
+	n, err = repo.Purge(ctx, t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	count, err := repo.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = repo.GetByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// key2 has no window left, so it is removed as well
+	err = db.View(func(tx *bbolt.Tx) error {
+		assert.Nil(t, tx.Bucket([]byte("ratelimit_counters")).Bucket([]byte("key2")))
+		assert.NotNil(t, tx.Bucket([]byte("ratelimit_counters")).Bucket([]byte("key1")))
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestPurge_DeletedKey(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, _, _ := newTestRepository(t, bolt.Opts{Expiration: time.Minute})
+
+	_, err := repo.IncrementByKey(ctx, "key1", t0)
+	require.NoError(t, err)
+	_, err = repo.IncrementByKey(ctx, "key2", t0)
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteByKey(ctx, "key1"))
+
+	// the index entries of the deleted windows are skipped
+	n, err := repo.Purge(ctx, t0.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestRunPurge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	repo, _, _ := newTestRepository(t, bolt.Opts{Expiration: time.Minute})
+
+	t0 := mockClock.Now()
+	_, err := repo.IncrementByKey(ctx, "key1", t0)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- repo.RunPurge(ctx, 30*time.Second, mockClock)
+	}()
+
+	// the clock is moved until the ticker has been created and the count
+	// has expired
+	assert.Eventually(t, func() bool {
+		mockClock.Add(30 * time.Second)
+		count, err := repo.GetByKey(ctx, "key1", t0)
+		return err == nil && count == 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestRunPurge_Error(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	purgeErrs := make(chan error, 10)
+	repo, db, _ := newTestRepository(t, bolt.Opts{
+		Expiration:   time.Minute,
+		OnPurgeError: func(err error) { purgeErrs <- err },
+	})
+	require.NoError(t, db.Close())
+
+	done := make(chan error)
+	go func() {
+		done <- repo.RunPurge(ctx, 30*time.Second, mockClock)
+	}()
+
+	// every failed purge is reported without stopping the next ones
+	var reported []error
+	assert.Eventually(t, func() bool {
+		mockClock.Add(30 * time.Second)
+		select {
+		case err := <-purgeErrs:
+			reported = append(reported, err)
+		default:
+		}
+		return len(reported) == 2
+	}, time.Second, 10*time.Millisecond)
+	for _, err := range reported {
+		assert.Contains(t, err.Error(), "failed to purge bolt counts")
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestRepository_DatabaseError(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, db, _ := newTestRepository(t, bolt.Opts{})
+	require.NoError(t, db.Close())
+
+	_, err := repo.IncrementByKey(ctx, "key1", t0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to increment bolt count")
+
+	_, err = repo.GetByKey(ctx, "key1", t0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get bolt count")
+}
+
+func TestFixedWindowRateLimiter_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	repo, db, path := newTestRepository(t, bolt.Opts{})
+
+	r := ratelimiter.NewFixedWindowRateLimiter(3, time.Minute, repo, mockClock)
+	res, err := r.AllowN(ctx, "user_123", 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Allowed)
+
+	// the limit is kept after restarting the server
+	require.NoError(t, db.Close())
+	repo, err = bolt.NewRepository(openDB(t, path), bolt.Opts{})
+	require.NoError(t, err)
+	r = ratelimiter.NewFixedWindowRateLimiter(3, time.Minute, repo, mockClock)
+
+	res, err = r.Allow(ctx, "user_123")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+
+	// the next window has its own limit
+	mockClock.Add(time.Minute)
+	res, err = r.Allow(ctx, "user_123")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 3, Remaining: 2}, res)
+}
@@ -0,0 +1,329 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+)
+
+// DefaultFlushInterval is the default of CachedOpts.FlushInterval
+const DefaultFlushInterval = 100 * time.Millisecond
+
+// CachedOpts stores the configuration options of CachedRepository
+type CachedOpts struct {
+	// FlushInterval is how often the local increments are flushed to the
+	// remote repository. Defaults to DefaultFlushInterval.
+	FlushInterval time.Duration
+
+	// FlushCount flushes the increments of a window as soon as it has
+	// that many of them, instead of waiting for the next flush. They are
+	// only flushed every FlushInterval if it is not set.
+	FlushCount int
+
+	// Limit is the limit of the rate limiter using the repository. If it
+	// is set, windows that are known to have reached it are no longer
+	// incremented nor flushed until they have ended.
+	Limit int
+
+	// Clock drives the flushes. Defaults to the system clock.
+	Clock clock.Clock
+}
+
+// CachedRepository is a Repository that counts the requests locally and
+// flushes the increments to a remote repository in the background, so
+// that most requests do not wait for the remote repository. The first
+// increment of a window is sent to the remote repository right away to
+// learn the count of the other instances.
+//
+// The local count does not include the increments of the other instances
+// since the last flush, so the limit may be overshot by up to the number
+// of requests that all instances allow in between flushes.
+//
+// It starts a goroutine to flush the increments, which has to be stopped
+// with Close.
+type CachedRepository struct {
+	remote     Repository
+	flushCount int
+	limit      int
+
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type cacheKey struct {
+	key    string
+	window int64
+}
+
+type cacheEntry struct {
+	window time.Time
+
+	// remote is the count of the remote repository as of the last flush,
+	// inflight are the increments being flushed, and pending are the
+	// increments that have not been flushed yet
+	remote   int
+	inflight int
+	pending  int
+
+	// loaded is closed once the first increment of the window has been
+	// flushed, and ready is set along with it
+	loaded chan struct{}
+	ready  bool
+
+	// touched is set when the window is incremented, and reset by every
+	// flush. Windows that are not touched in between flushes are evicted.
+	touched bool
+
+	// flushMu serializes the flushes of the window, so that their counts
+	// are applied in order
+	flushMu sync.Mutex
+}
+
+// count must be called while holding the lock
+func (e *cacheEntry) count() int {
+	return e.remote + e.inflight + e.pending
+}
+
+// NewCachedRepository returns a new instance of cached repository in
+// front of the given remote repository. Example:
+//
+//   repo := repository.NewCachedRepository(redisRepo, repository.CachedOpts{Limit: 100})
+//   defer repo.Close()
+//   rateLimiter := ratelimiter.NewFixedWindowRateLimiter(100, time.Minute, repo, clock)
+func NewCachedRepository(remote Repository, opts CachedOpts) *CachedRepository {
+	interval := opts.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	c := opts.Clock
+	if c == nil {
+		c = clock.New()
+	}
+
+	r := &CachedRepository{
+		remote:     remote,
+		flushCount: opts.FlushCount,
+		limit:      opts.Limit,
+		entries:    map[cacheKey]*cacheEntry{},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go r.run(c.Ticker(interval))
+	return r
+}
+
+// Close stops flushing in the background, then flushes the increments
+// that are left. It returns the error of the last flush if any.
+func (r *CachedRepository) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+		r.closeErr = r.Flush(context.Background())
+	})
+	return r.closeErr
+}
+
+func (r *CachedRepository) run(ticker *clock.Ticker) {
+	defer close(r.done)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			// failed increments are kept to be flushed again next time
+			_ = r.Flush(context.Background())
+		}
+	}
+}
+
+// Flush sends the local increments of every window to the remote
+// repository, and evicts the windows that have not been incremented since
+// the previous flush. It returns the first error, but still flushes the
+// rest of the windows.
+func (r *CachedRepository) Flush(ctx context.Context) error {
+	var keys []cacheKey
+	var entries []*cacheEntry
+	r.mu.Lock()
+	for ck, e := range r.entries {
+		switch {
+		case !e.ready:
+		case e.pending != 0:
+			keys = append(keys, ck)
+			entries = append(entries, e)
+		case !e.touched && e.inflight == 0:
+			delete(r.entries, ck)
+		}
+		e.touched = false
+	}
+	r.mu.Unlock()
+
+	var firstErr error
+	for i, e := range entries {
+		if _, err := r.flush(ctx, keys[i].key, e); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// flush sends the pending increments of the given window to the remote
+// repository and returns the local count afterwards. The increments are
+// put back to be flushed again if it fails.
+func (r *CachedRepository) flush(ctx context.Context, key string, e *cacheEntry) (int, error) {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	r.mu.Lock()
+	delta := e.pending
+	e.pending = 0
+	e.inflight += delta
+	r.mu.Unlock()
+
+	count, err := r.remote.IncrementByKeyN(ctx, key, e.window, delta)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.inflight -= delta
+	if err != nil {
+		e.pending += delta
+		return 0, errors.Wrap(err, "failed to flush increments to remote repository")
+	}
+	e.remote = count
+	return e.count(), nil
+}
+
+// IncrementByKey increases the request count for the given key and
+// window by 1
+func (r *CachedRepository) IncrementByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	return r.IncrementByKeyN(ctx, key, window, 1)
+}
+
+// IncrementByKeyN increases the local request count for the given key
+// and window by n and returns the estimated count of every instance. A
+// failure to flush after FlushCount is not returned, as the increment is
+// still counted locally and flushed again later.
+func (r *CachedRepository) IncrementByKeyN(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	ck := cacheKey{key: key, window: window.UnixNano()}
+	for {
+		r.mu.Lock()
+		e, ok := r.entries[ck]
+		if !ok {
+			return r.load(ctx, ck, window, n)
+		}
+		if !e.ready {
+			// wait for the first increment to learn the remote count
+			r.mu.Unlock()
+			select {
+			case <-e.loaded:
+				continue
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+
+		e.touched = true
+		if r.limit > 0 && n > 0 && e.remote >= r.limit {
+			// the window is known to have reached the limit, there is no
+			// point in counting the requests that are rejected anyway
+			count := e.count() + n
+			r.mu.Unlock()
+			return count, nil
+		}
+
+		e.pending += n
+		count := e.count()
+		flushNow := r.flushCount > 0 && e.pending >= r.flushCount
+		r.mu.Unlock()
+
+		if flushNow {
+			if flushed, err := r.flush(ctx, key, e); err == nil {
+				count = flushed
+			}
+		}
+		return count, nil
+	}
+}
+
+// load adds the given window and sends its first increment to the remote
+// repository. It must be called while holding the lock, which it
+// releases.
+func (r *CachedRepository) load(ctx context.Context, ck cacheKey, window time.Time, n int) (int, error) {
+	e := &cacheEntry{
+		window:  window,
+		pending: n,
+		loaded:  make(chan struct{}),
+		touched: true,
+	}
+	r.entries[ck] = e
+	r.mu.Unlock()
+	defer close(e.loaded)
+
+	count, err := r.flush(ctx, ck.key, e)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		// the increment is not counted as the caller gets the error, and
+		// the next increment tries to load the window again
+		delete(r.entries, ck)
+		return 0, err
+	}
+	e.ready = true
+	return count, nil
+}
+
+// GetByKey returns the estimated request count for the given key and
+// window, or the remote count if the window is not cached
+func (r *CachedRepository) GetByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	r.mu.Lock()
+	e, ok := r.entries[cacheKey{key: key, window: window.UnixNano()}]
+	if ok && e.ready {
+		count := e.count()
+		r.mu.Unlock()
+		return count, nil
+	}
+	r.mu.Unlock()
+	return r.remote.GetByKey(ctx, key, window)
+}
+
+// DecrementByKey decreases the request count for the given key and
+// window by n, but not below zero. The decrement of a cached window is
+// flushed along with its increments.
+func (r *CachedRepository) DecrementByKey(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	r.mu.Lock()
+	e, ok := r.entries[cacheKey{key: key, window: window.UnixNano()}]
+	if !ok || !e.ready {
+		r.mu.Unlock()
+		return r.remote.DecrementByKey(ctx, key, window, n)
+	}
+	defer r.mu.Unlock()
+
+	e.pending -= n
+	if count := e.count(); count < 0 {
+		e.pending -= count
+	}
+	return e.count(), nil
+}
+
+// DeleteByKey removes the cached windows of the given key along with
+// their pending increments, and the request counts of the remote
+// repository
+func (r *CachedRepository) DeleteByKey(ctx context.Context, key string) error {
+	r.mu.Lock()
+	for ck := range r.entries {
+		if ck.key == key {
+			delete(r.entries, ck)
+		}
+	}
+	r.mu.Unlock()
+	return r.remote.DeleteByKey(ctx, key)
+}
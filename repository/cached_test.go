@@ -0,0 +1,242 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+func TestCachedRepository_BatchesIncrements(t *testing.T) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	t0 := time.Unix(1600000000, 0)
+	remote := repository.NewInMemRepository()
+	cached := repository.NewCachedRepository(remote, repository.CachedOpts{
+		FlushInterval: time.Second,
+		Clock:         mockClock,
+	})
+	defer cached.Close()
+
+	// the first increment learns the count of the other instances
+	_, err := remote.IncrementByKeyN(ctx, "key1", t0, 10)
+	require.NoError(t, err)
+	count, err := cached.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 11, count)
+
+	// the next increments are only counted locally
+	for i := 0; i < 4; i++ {
+		count, err = cached.IncrementByKey(ctx, "key1", t0)
+		assert.NoError(t, err)
+		assert.Equal(t, 12+i, count)
+	}
+	count, err = remote.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 11, count)
+	count, err = cached.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 15, count)
+
+	// until they are flushed, which also picks up the increments of the
+	// other instances in the meantime
+	_, err = remote.IncrementByKeyN(ctx, "key1", t0, 5)
+	require.NoError(t, err)
+	mockClock.Add(time.Second)
+	assert.Eventually(t, func() bool {
+		count, _ := cached.GetByKey(ctx, "key1", t0)
+		return count == 20
+	}, time.Second, time.Millisecond)
+	count, err = remote.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, count)
+}
+
+func TestCachedRepository_FlushCount(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	remote := repository.NewInMemRepository()
+	cached := repository.NewCachedRepository(remote, repository.CachedOpts{
+		FlushInterval: time.Hour,
+		FlushCount:    3,
+		Clock:         clock.NewMock(),
+	})
+	defer cached.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := cached.IncrementByKey(ctx, "key1", t0)
+		require.NoError(t, err)
+	}
+	count, err := remote.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// the third pending increment flushes the window
+	count, err = cached.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	count, err = remote.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+}
+
+func TestCachedRepository_Limit(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	remote := repository.NewInMemRepository()
+	cached := repository.NewCachedRepository(remote, repository.CachedOpts{
+		Limit: 3,
+		Clock: clock.NewMock(),
+	})
+	defer cached.Close()
+
+	_, err := remote.IncrementByKeyN(ctx, "key1", t0, 3)
+	require.NoError(t, err)
+	count, err := cached.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	// the window is known to have reached the limit, so the requests are
+	// no longer counted
+	count, err = cached.IncrementByKeyN(ctx, "key1", t0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+	assert.NoError(t, cached.Flush(ctx))
+	count, err = remote.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	// the next window has its own count
+	count, err = cached.IncrementByKey(ctx, "key1", t0.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestCachedRepository_DecrementAndDeleteByKey(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	remote := repository.NewInMemRepository()
+	cached := repository.NewCachedRepository(remote, repository.CachedOpts{Clock: clock.NewMock()})
+
+	_, err := cached.IncrementByKeyN(ctx, "key1", t0, 2)
+	require.NoError(t, err)
+	_, err = cached.IncrementByKeyN(ctx, "key1", t0, 3)
+	require.NoError(t, err)
+
+	// the decrement is counted locally, but not below zero
+	count, err := cached.DecrementByKey(ctx, "key1", t0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	count, err = cached.DecrementByKey(ctx, "key1", t0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.NoError(t, cached.Flush(ctx))
+	count, err = remote.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// a window that is not cached is decremented remotely
+	_, err = remote.IncrementByKeyN(ctx, "key2", t0, 2)
+	require.NoError(t, err)
+	count, err = cached.DecrementByKey(ctx, "key2", t0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// deleting drops the pending increments
+	_, err = cached.IncrementByKeyN(ctx, "key1", t0, 2)
+	require.NoError(t, err)
+	assert.NoError(t, cached.DeleteByKey(ctx, "key1"))
+	assert.NoError(t, cached.Close())
+	count, err = remote.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = cached.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestCachedRepository_Close(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	remote := repository.NewInMemRepository()
+	cached := repository.NewCachedRepository(remote, repository.CachedOpts{Clock: clock.NewMock()})
+
+	for i := 0; i < 3; i++ {
+		_, err := cached.IncrementByKey(ctx, "key1", t0)
+		require.NoError(t, err)
+	}
+
+	// the increments that are left are flushed on close
+	assert.NoError(t, cached.Close())
+	assert.NoError(t, cached.Close())
+	count, err := remote.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestCachedRepository_RaceCondition(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	remote := repository.NewInMemRepository()
+	cached := repository.NewCachedRepository(remote, repository.CachedOpts{
+		FlushInterval: time.Millisecond,
+		FlushCount:    7,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cached.IncrementByKey(ctx, "key1", t0)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// every increment is flushed exactly once
+	assert.NoError(t, cached.Close())
+	count, err := remote.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, count)
+}
+
+func TestCachedRepository_RemoteError(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	ctrl := gomock.NewController(t)
+	remote := mocks.NewMockRepository(ctrl)
+	cached := repository.NewCachedRepository(remote, repository.CachedOpts{Clock: clock.NewMock()})
+	remoteErr := errors.New("connection refused")
+
+	// the first increment of a window fails along with the remote
+	remote.EXPECT().IncrementByKeyN(gomock.Any(), "key1", t0, 1).Return(0, remoteErr)
+	_, err := cached.IncrementByKey(ctx, "key1", t0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to flush increments to remote repository")
+
+	// failed flushes are retried with the increments in the meantime
+	gomock.InOrder(
+		remote.EXPECT().IncrementByKeyN(gomock.Any(), "key1", t0, 1).Return(1, nil),
+		remote.EXPECT().IncrementByKeyN(gomock.Any(), "key1", t0, 2).Return(0, remoteErr),
+		remote.EXPECT().IncrementByKeyN(gomock.Any(), "key1", t0, 3).Return(4, nil),
+	)
+	_, err = cached.IncrementByKey(ctx, "key1", t0)
+	require.NoError(t, err)
+	_, err = cached.IncrementByKeyN(ctx, "key1", t0, 2)
+	require.NoError(t, err)
+	assert.Error(t, cached.Flush(ctx))
+
+	count, err := cached.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.NoError(t, cached.Close())
+}
@@ -1,19 +1,70 @@
 package repository
 
 import (
+	"container/list"
 	"context"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/benbjohnson/clock"
 )
 
 // InMemRepository is repository implementation with a Go in-mem map.
 // Note that on server restarts, the rate limit will be reset due to
-// in-mem approach.
+// in-mem approach. See repository/bolt for a repository of a single node
+// deployment that survives restarts.
+//
+// The keys are kept forever by default. Use NewInMemRepositoryWithOpts to
+// remove the state of a key once it has expired, and to bound the number
+// of keys.
 type InMemRepository struct {
-	mu    sync.Mutex
-	store map[string]*windowObj
+	mu         sync.Mutex
+	store      map[string][]*windowObj
+	logs       map[string][]time.Time
+	buckets    map[string]TokenBucket
+	queues     map[string]time.Time
+	timestamps map[string]time.Time
+
+	// lru orders the keys from the most to the least recently updated,
+	// and lruKeys indexes its elements. They are only kept when maxKeys
+	// is set.
+	maxKeys int
+	lru     *list.List
+	lruKeys map[string]*list.Element
+
+	expiration time.Duration
+	stop       chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// InMemOpts stores the configuration options of InMemRepository
+type InMemOpts struct {
+	// Expiration is how long the state of a key is kept after it was last
+	// needed, e.g. after the start of a window or the last refill of a
+	// token bucket, see Repository for how long it needs to be. The state
+	// is kept forever if it is not set.
+	Expiration time.Duration
+
+	// JanitorInterval is how often the expired state is removed in the
+	// background. Defaults to Expiration.
+	JanitorInterval time.Duration
+
+	// MaxKeys is the maximum number of keys to keep. The least recently
+	// updated key is evicted along with all of its state when a new key
+	// would exceed it, which resets its rate limits. There is no maximum
+	// if it is not set.
+	MaxKeys int
+
+	// Clock drives the janitor. Defaults to the system clock.
+	Clock clock.Clock
 }
 
+// maxWindows is the number of most recent time windows that are kept
+// for each key i.e. the previous, current, and next time window
+const maxWindows = 3
+
 type windowObj struct {
 	time  time.Time
 	count int
@@ -21,37 +72,530 @@ type windowObj struct {
 
 // NewInMemRepository returns a new instance of in-mem repository
 func NewInMemRepository() *InMemRepository {
-	return &InMemRepository{
-		store: map[string]*windowObj{},
+	return NewInMemRepositoryWithOpts(InMemOpts{})
+}
+
+// NewInMemRepositoryWithOpts returns a new instance of in-mem repository
+// with the given options. If Expiration is set, it starts a janitor in
+// the background, which has to be stopped with Close.
+func NewInMemRepositoryWithOpts(opts InMemOpts) *InMemRepository {
+	r := &InMemRepository{
+		store:      map[string][]*windowObj{},
+		logs:       map[string][]time.Time{},
+		buckets:    map[string]TokenBucket{},
+		queues:     map[string]time.Time{},
+		timestamps: map[string]time.Time{},
+		maxKeys:    opts.MaxKeys,
+		expiration: opts.Expiration,
+	}
+	if r.maxKeys > 0 {
+		r.lru = list.New()
+		r.lruKeys = map[string]*list.Element{}
+	}
+
+	if r.expiration > 0 {
+		interval := opts.JanitorInterval
+		if interval <= 0 {
+			interval = r.expiration
+		}
+		c := opts.Clock
+		if c == nil {
+			c = clock.New()
+		}
+		r.stop = make(chan struct{})
+		r.done = make(chan struct{})
+		go r.runJanitor(c.Ticker(interval))
+	}
+	return r
+}
+
+// Close stops the janitor of the repository if it has one. The
+// repository can still be used afterwards, but the expired state is no
+// longer removed.
+func (r *InMemRepository) Close() error {
+	if r.stop == nil {
+		return nil
+	}
+	r.closeOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+	return nil
+}
+
+func (r *InMemRepository) runJanitor(ticker *clock.Ticker) {
+	defer close(r.done)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			r.mu.Lock()
+			r.removeExpired(now)
+			r.mu.Unlock()
+		}
+	}
+}
+
+// removeExpired removes the state that has expired at now. Queues and
+// timestamps that are not after now are the same as their zero value, so
+// they are removed regardless of the expiration. It must be called while
+// holding the lock.
+func (r *InMemRepository) removeExpired(now time.Time) {
+	since := now.Add(-r.expiration)
+	for key, windows := range r.store {
+		i := 0
+		for i < len(windows) && !windows[i].time.After(since) {
+			i++
+		}
+		if i == len(windows) {
+			delete(r.store, key)
+			r.forget(key)
+		} else if i > 0 {
+			r.store[key] = windows[i:]
+		}
+	}
+	for key, log := range r.logs {
+		i := 0
+		for i < len(log) && !log[i].After(since) {
+			i++
+		}
+		if i == len(log) {
+			delete(r.logs, key)
+			r.forget(key)
+		} else if i > 0 {
+			r.logs[key] = log[i:]
+		}
+	}
+	for key, b := range r.buckets {
+		if !b.LastRefill.After(since) {
+			delete(r.buckets, key)
+			r.forget(key)
+		}
+	}
+	for key, emptyAt := range r.queues {
+		if !emptyAt.After(now) {
+			delete(r.queues, key)
+			r.forget(key)
+		}
+	}
+	for key, tat := range r.timestamps {
+		if !tat.After(now) {
+			delete(r.timestamps, key)
+			r.forget(key)
+		}
+	}
+}
+
+// touch marks the given key as the most recently updated, and evicts the
+// least recently updated key if there are more than maxKeys. It must be
+// called while holding the lock.
+func (r *InMemRepository) touch(key string) {
+	if r.lru == nil {
+		return
+	}
+	if e, ok := r.lruKeys[key]; ok {
+		r.lru.MoveToFront(e)
+		return
+	}
+	r.lruKeys[key] = r.lru.PushFront(key)
+	if r.lru.Len() > r.maxKeys {
+		oldest := r.lru.Remove(r.lru.Back()).(string)
+		delete(r.lruKeys, oldest)
+		delete(r.store, oldest)
+		delete(r.logs, oldest)
+		delete(r.buckets, oldest)
+		delete(r.queues, oldest)
+		delete(r.timestamps, oldest)
+	}
+}
+
+// forget stops tracking the given key for eviction once none of its
+// state is left. It must be called while holding the lock.
+func (r *InMemRepository) forget(key string) {
+	if r.lru == nil {
+		return
+	}
+	if _, ok := r.store[key]; ok {
+		return
+	}
+	if _, ok := r.logs[key]; ok {
+		return
+	}
+	if _, ok := r.buckets[key]; ok {
+		return
+	}
+	if _, ok := r.queues[key]; ok {
+		return
+	}
+	if _, ok := r.timestamps[key]; ok {
+		return
+	}
+	if e, ok := r.lruKeys[key]; ok {
+		r.lru.Remove(e)
+		delete(r.lruKeys, key)
 	}
 }
 
 // IncrementByKey increases the request count for the given key and
-// current window by 1. It only keeps track of one time window i.e.
-// when a request comes with new time window, it will reset the count
-// and lost the count of the previous time window.
+// current window by 1. It only keeps track of the 3 most recent time
+// windows of each key i.e. the previous window for sliding window, the
+// current window, and the next window that may hold reservations. When
+// a request comes with a newer time window, the count of the oldest
+// time window is lost.
 //
 // This is an optimization for limiting the memory usage based on the
-// assumption that only the current time window need to be keep tracked
-// of. Otherwise there is a need to clean up stale time windows.
+// assumption that only the most recent time windows need to be keep
+// tracked of. Otherwise there is a need to clean up stale time windows.
 //
 // This method is thread-safe with a sync.Mutex. Note that the current
 // implementation of mutex locks the entire map regardless of which key
-// is being accessed. Ideally different key could operate independently.
-// That is the trade off that is made at this point and may be refactored
-// to lock at key-level if needed in the future.
+// is being accessed. Use ShardedInMemRepository for requests of
+// different keys to contend less with each other.
 func (r *InMemRepository) IncrementByKey(ctx context.Context, key string, window time.Time) (int, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	w, ok := r.store[key]
-	if !ok || (ok && !w.time.Equal(window)) {
-		r.store[key] = &windowObj{
-			time:  window,
-			count: 1,
-		}
-		return 1, nil
+	w := r.increment(key, window, 1)
+	return w.count, nil
+}
+
+// IncrementByKeyN increases the request count for the given key and
+// current window by n. It keeps track of the time windows in the same
+// way as IncrementByKey.
+func (r *InMemRepository) IncrementByKeyN(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := r.increment(key, window, n)
+	return w.count, nil
+}
+
+// GetByKey returns the request count for the given key and window if
+// it is still tracked
+func (r *InMemRepository) GetByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w := r.find(key, window); w != nil {
+		return w.count, nil
 	}
+	return 0, nil
+}
 
-	w.count++
+// DecrementByKey decreases the request count for the given key and
+// window by n if it is still tracked. The count never goes below zero.
+func (r *InMemRepository) DecrementByKey(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := r.find(key, window)
+	if w == nil {
+		return 0, nil
+	}
+	w.count -= n
+	if w.count < 0 {
+		w.count = 0
+	}
 	return w.count, nil
 }
+
+// DeleteByKey removes every tracked window of the given key
+func (r *InMemRepository) DeleteByKey(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.store, key)
+	r.forget(key)
+	return nil
+}
+
+// IncrementWithPrevious increases the request count for the given key
+// and current window by n just like IncrementByKeyN. It also returns the
+// count of prevWindow if it is still tracked.
+func (r *InMemRepository) IncrementWithPrevious(ctx context.Context, key string, window, prevWindow time.Time, n int) (int, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := r.increment(key, window, n)
+	prevCount := 0
+	if prev := r.find(key, prevWindow); prev != nil {
+		prevCount = prev.count
+	}
+	return w.count, prevCount, nil
+}
+
+// GetWithPrevious returns the request counts for the given key of window
+// and prevWindow if they are still tracked
+func (r *InMemRepository) GetWithPrevious(ctx context.Context, key string, window, prevWindow time.Time) (int, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count, prevCount := 0, 0
+	if w := r.find(key, window); w != nil {
+		count = w.count
+	}
+	if prev := r.find(key, prevWindow); prev != nil {
+		prevCount = prev.count
+	}
+	return count, prevCount, nil
+}
+
+// increment must be called while holding the lock
+func (r *InMemRepository) increment(key string, window time.Time, n int) *windowObj {
+	r.touch(key)
+	windows := r.store[key]
+	i := sort.Search(len(windows), func(i int) bool {
+		return !windows[i].time.Before(window)
+	})
+	if i < len(windows) && windows[i].time.Equal(window) {
+		windows[i].count += n
+		return windows[i]
+	}
+
+	// keep the windows sorted by time and drop the oldest ones
+	w := &windowObj{
+		time:  window,
+		count: n,
+	}
+	windows = append(windows, nil)
+	copy(windows[i+1:], windows[i:])
+	windows[i] = w
+	if len(windows) > maxWindows {
+		windows = windows[len(windows)-maxWindows:]
+	}
+	r.store[key] = windows
+	return w
+}
+
+// find returns the given window of the key if it is tracked. It must be
+// called while holding the lock.
+func (r *InMemRepository) find(key string, window time.Time) *windowObj {
+	for _, w := range r.store[key] {
+		if w.time.Equal(window) {
+			return w
+		}
+	}
+	return nil
+}
+
+// AppendLog drops the timestamps of the given key that are not after
+// since and appends now n times to the log if there is room for them.
+// Stale timestamps are only pruned when the key is accessed again.
+//
+// The returned slice is a copy of the log so that the caller can read
+// it without holding the lock.
+func (r *InMemRepository) AppendLog(ctx context.Context, key string, now, since time.Time, limit, n int) ([]time.Time, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.touch(key)
+	log := r.logs[key]
+
+	// timestamps are appended in order, so everything before the first
+	// timestamp after since has expired
+	i := 0
+	for i < len(log) && !log[i].After(since) {
+		i++
+	}
+	log = log[i:]
+
+	appended := false
+	if len(log)+n <= limit {
+		for i := 0; i < n; i++ {
+			log = append(log, now)
+		}
+		appended = true
+	}
+	r.logs[key] = log
+
+	res := make([]time.Time, len(log))
+	copy(res, log)
+	return res, appended, nil
+}
+
+// GetLog returns a copy of the timestamps of the given key that are
+// after since. Stale timestamps are left for AppendLog to prune.
+func (r *InMemRepository) GetLog(ctx context.Context, key string, since time.Time) ([]time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log := r.logs[key]
+	i := 0
+	for i < len(log) && !log[i].After(since) {
+		i++
+	}
+
+	res := make([]time.Time, len(log)-i)
+	copy(res, log[i:])
+	return res, nil
+}
+
+// PopLog removes up to n of the newest timestamps of the given key
+func (r *InMemRepository) PopLog(ctx context.Context, key string, n int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log, ok := r.logs[key]
+	if !ok {
+		return nil
+	}
+	if n > len(log) {
+		n = len(log)
+	}
+	r.logs[key] = log[:len(log)-n]
+	return nil
+}
+
+// DeleteLog removes the log of the given key
+func (r *InMemRepository) DeleteLog(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.logs, key)
+	r.forget(key)
+	return nil
+}
+
+// TakeTokens refills the bucket of the given key and takes n tokens out
+// of it if there are enough. Refer to TokenBucket.Refill for how the
+// bucket is refilled.
+func (r *InMemRepository) TakeTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (TokenBucket, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.touch(key)
+	b := r.refill(key, now, interval, capacity)
+
+	taken := false
+	if b.Tokens >= n {
+		b.Tokens -= n
+		taken = true
+	}
+	r.buckets[key] = b
+	return b, taken, nil
+}
+
+// ReserveTokens refills the bucket of the given key and takes n tokens
+// out of it, going into debt if there are not enough
+func (r *InMemRepository) ReserveTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (TokenBucket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.touch(key)
+	b := r.refill(key, now, interval, capacity)
+	b.Tokens -= n
+	r.buckets[key] = b
+	return b, nil
+}
+
+// PutTokens refills the bucket of the given key and puts n tokens back
+// into it, up to capacity
+func (r *InMemRepository) PutTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (TokenBucket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.touch(key)
+	b := r.refill(key, now, interval, capacity)
+	b.Tokens += n
+	if b.Tokens >= capacity {
+		b = TokenBucket{Tokens: capacity, LastRefill: now}
+	}
+	r.buckets[key] = b
+	return b, nil
+}
+
+// GetTokens returns the refilled bucket of the given key without storing
+// it
+func (r *InMemRepository) GetTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity int) (TokenBucket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.refill(key, now, interval, capacity), nil
+}
+
+// DeleteTokens removes the bucket of the given key
+func (r *InMemRepository) DeleteTokens(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.buckets, key)
+	r.forget(key)
+	return nil
+}
+
+// refill returns the refilled bucket of the given key, where a bucket
+// that does not exist yet starts full. It must be called while holding
+// the lock.
+func (r *InMemRepository) refill(key string, now time.Time, interval time.Duration, capacity int) TokenBucket {
+	b, ok := r.buckets[key]
+	if !ok {
+		b = TokenBucket{Tokens: capacity, LastRefill: now}
+	}
+	return b.Refill(now, interval, capacity)
+}
+
+// Enqueue adds n requests to the queue of the given key if they fit.
+// The queue only needs the time when it will be empty, as every request
+// in it takes exactly one interval to drain.
+func (r *InMemRepository) Enqueue(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (time.Time, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	emptyAt := r.queues[key]
+	if emptyAt.Before(now) {
+		emptyAt = now
+	}
+
+	next := emptyAt.Add(time.Duration(n) * interval)
+	if next.Sub(now) > time.Duration(capacity)*interval {
+		return emptyAt, false, nil
+	}
+	r.touch(key)
+	r.queues[key] = next
+	return next, true, nil
+}
+
+// GetEmptyAt returns the time when the queue of the given key will be
+// empty
+func (r *InMemRepository) GetEmptyAt(ctx context.Context, key string) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queues[key], nil
+}
+
+// Dequeue moves the time when the queue of the given key will be empty
+// back by n intervals, but not before now
+func (r *InMemRepository) Dequeue(ctx context.Context, key string, now time.Time, interval time.Duration, n int) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	emptyAt := r.queues[key].Add(-time.Duration(n) * interval)
+	if emptyAt.Before(now) {
+		emptyAt = now
+	}
+	r.touch(key)
+	r.queues[key] = emptyAt
+	return emptyAt, nil
+}
+
+// DeleteQueue removes the queue of the given key
+func (r *InMemRepository) DeleteQueue(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.queues, key)
+	r.forget(key)
+	return nil
+}
+
+// GetTimestamp returns the timestamp of the given key
+func (r *InMemRepository) GetTimestamp(ctx context.Context, key string) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.timestamps[key], nil
+}
+
+// CompareAndSetTimestamp sets the timestamp of the given key to value
+// if it has not been changed from expected
+func (r *InMemRepository) CompareAndSetTimestamp(ctx context.Context, key string, expected, value time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.timestamps[key].Equal(expected) {
+		return false, nil
+	}
+	r.touch(key)
+	r.timestamps[key] = value
+	return true, nil
+}
+
+// DeleteTimestamp removes the timestamp of the given key
+func (r *InMemRepository) DeleteTimestamp(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.timestamps, key)
+	r.forget(key)
+	return nil
+}
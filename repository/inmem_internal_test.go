@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemRepository_PopLogUnknownKey(t *testing.T) {
+	inMem := NewInMemRepositoryWithOpts(InMemOpts{MaxKeys: 1})
+
+	// popping the log of a key that has none does not track the key
+	for _, key := range []string{"key1", "key2", "key3"} {
+		assert.NoError(t, inMem.PopLog(context.Background(), key, 1))
+	}
+	assert.Empty(t, inMem.logs)
+}
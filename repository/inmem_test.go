@@ -41,11 +41,24 @@ func TestIncrementByKey(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 4, count)
 
-	// increment key1 with different window t0+5min, should reset windowObj
+	// increment key1 with different window t0+5min, should start a new windowObj
 	count, err = inMem.IncrementByKey(ctx, "key1", mockClock.Now().Add(5*time.Minute))
 	assert.NoError(t, err)
 	assert.Equal(t, 1, count)
 
+	// increment key1 with the first time window t0, which is still tracked
+	count, err = inMem.IncrementByKey(ctx, "key1", mockClock.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 5, count)
+
+	// increment key1 with newer windows t0+10min and t0+15min, should drop t0
+	count, err = inMem.IncrementByKey(ctx, "key1", mockClock.Now().Add(10*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = inMem.IncrementByKey(ctx, "key1", mockClock.Now().Add(15*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
 	// increment key1 with the first time window t0, restarted from 0
 	count, err = inMem.IncrementByKey(ctx, "key1", mockClock.Now())
 	assert.NoError(t, err)
@@ -97,3 +110,523 @@ func TestIncrementByKey_RaceCondition(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestAppendLog(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	inMem := repository.NewInMemRepository()
+
+	// append to key1 until the limit is reached
+	log, appended, err := inMem.AppendLog(ctx, "key1", t0, t0.Add(-time.Minute), 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, appended)
+	assert.Equal(t, []time.Time{t0}, log)
+
+	log, appended, err = inMem.AppendLog(ctx, "key1", t0.Add(time.Second), t0.Add(-59*time.Second), 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, appended)
+	assert.Equal(t, []time.Time{t0, t0.Add(time.Second)}, log)
+
+	// key1 is full, should not append
+	log, appended, err = inMem.AppendLog(ctx, "key1", t0.Add(2*time.Second), t0.Add(-58*time.Second), 2, 1)
+	assert.NoError(t, err)
+	assert.False(t, appended)
+	assert.Equal(t, []time.Time{t0, t0.Add(time.Second)}, log)
+
+	// key2 has its own log
+	log, appended, err = inMem.AppendLog(ctx, "key2", t0.Add(2*time.Second), t0.Add(-58*time.Second), 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, appended)
+	assert.Equal(t, []time.Time{t0.Add(2 * time.Second)}, log)
+
+	// t0 is no longer after since, should be pruned to make room
+	log, appended, err = inMem.AppendLog(ctx, "key1", t0.Add(time.Minute), t0, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, appended)
+	assert.Equal(t, []time.Time{t0.Add(time.Second), t0.Add(time.Minute)}, log)
+}
+
+func TestIncrementWithPrevious(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	t1 := t0.Add(time.Minute)
+	t2 := t1.Add(time.Minute)
+	inMem := repository.NewInMemRepository()
+
+	// there is no previous window yet
+	count, prevCount, err := inMem.IncrementWithPrevious(ctx, "key1", t0, t0.Add(-time.Minute), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 0, prevCount)
+
+	count, err = inMem.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// t0 becomes the previous window
+	count, prevCount, err = inMem.IncrementWithPrevious(ctx, "key1", t1, t0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 2, prevCount)
+
+	count, prevCount, err = inMem.IncrementWithPrevious(ctx, "key1", t1, t0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 2, prevCount)
+
+	// skipping a window should not carry over t0
+	count, prevCount, err = inMem.IncrementWithPrevious(ctx, "key1", t2.Add(time.Minute), t2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 0, prevCount)
+}
+
+func TestTakeToken(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	inMem := repository.NewInMemRepository()
+
+	// new bucket starts full
+	b, taken, err := inMem.TakeTokens(ctx, "key1", t0, time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 1, LastRefill: t0}, b)
+
+	b, taken, err = inMem.TakeTokens(ctx, "key1", t0.Add(500*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 0, LastRefill: t0}, b)
+
+	// key1 is empty
+	b, taken, err = inMem.TakeTokens(ctx, "key1", t0.Add(900*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.False(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 0, LastRefill: t0}, b)
+
+	// key2 has its own bucket
+	b, taken, err = inMem.TakeTokens(ctx, "key2", t0.Add(900*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 1, LastRefill: t0.Add(900 * time.Millisecond)}, b)
+
+	// 1 token is refilled, the partial interval carries over to the next refill
+	b, taken, err = inMem.TakeTokens(ctx, "key1", t0.Add(1500*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 0, LastRefill: t0.Add(time.Second)}, b)
+
+	// refill is capped at capacity
+	b, taken, err = inMem.TakeTokens(ctx, "key1", t0.Add(time.Minute), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 1, LastRefill: t0.Add(time.Minute)}, b)
+}
+
+func TestEnqueue(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	inMem := repository.NewInMemRepository()
+
+	// empty queue drains the request after an interval
+	emptyAt, ok, err := inMem.Enqueue(ctx, "key1", t0, time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(time.Second), emptyAt)
+
+	emptyAt, ok, err = inMem.Enqueue(ctx, "key1", t0, time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(2*time.Second), emptyAt)
+
+	// key1 is full
+	emptyAt, ok, err = inMem.Enqueue(ctx, "key1", t0.Add(500*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, t0.Add(2*time.Second), emptyAt)
+
+	// key2 has its own queue
+	emptyAt, ok, err = inMem.Enqueue(ctx, "key2", t0.Add(500*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(1500*time.Millisecond), emptyAt)
+
+	// key1 has drained a request
+	emptyAt, ok, err = inMem.Enqueue(ctx, "key1", t0.Add(time.Second), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(3*time.Second), emptyAt)
+
+	// key1 has drained completely
+	emptyAt, ok, err = inMem.Enqueue(ctx, "key1", t0.Add(time.Minute), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(time.Minute+time.Second), emptyAt)
+}
+
+func TestCompareAndSetTimestamp(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	inMem := repository.NewInMemRepository()
+
+	// key1 does not exist yet
+	ts, err := inMem.GetTimestamp(ctx, "key1")
+	assert.NoError(t, err)
+	assert.True(t, ts.IsZero())
+
+	ok, err := inMem.CompareAndSetTimestamp(ctx, "key1", time.Time{}, t0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// key1 is no longer zero value
+	ok, err = inMem.CompareAndSetTimestamp(ctx, "key1", time.Time{}, t0.Add(time.Second))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = inMem.CompareAndSetTimestamp(ctx, "key1", t0, t0.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ts, err = inMem.GetTimestamp(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(time.Second), ts)
+}
+
+func TestIncrementByKeyN(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	inMem := repository.NewInMemRepository()
+
+	count, err := inMem.IncrementByKeyN(ctx, "key1", mockClock.Now(), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	count, err = inMem.IncrementByKey(ctx, "key1", mockClock.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	// revert the previous increment
+	count, err = inMem.IncrementByKeyN(ctx, "key1", mockClock.Now(), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// new window starts from n
+	count, err = inMem.IncrementByKeyN(ctx, "key1", mockClock.Now().Add(time.Minute), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestReserveAndPutTokens(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	inMem := repository.NewInMemRepository()
+
+	// new bucket starts full and goes into debt
+	b, err := inMem.ReserveTokens(ctx, "key1", t0, time.Second, 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: -1, LastRefill: t0}, b)
+
+	// bucket in debt cannot give tokens
+	b, taken, err := inMem.TakeTokens(ctx, "key1", t0.Add(time.Second), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.False(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 0, LastRefill: t0.Add(time.Second)}, b)
+
+	// putting tokens back is capped at capacity
+	b, err = inMem.PutTokens(ctx, "key1", t0.Add(1500*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: 1, LastRefill: t0.Add(time.Second)}, b)
+
+	b, err = inMem.PutTokens(ctx, "key1", t0.Add(1500*time.Millisecond), time.Second, 2, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: 2, LastRefill: t0.Add(1500 * time.Millisecond)}, b)
+}
+
+func TestGetByKey(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	t1 := t0.Add(time.Minute)
+	inMem := repository.NewInMemRepository()
+
+	count, err := inMem.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	_, err = inMem.IncrementByKeyN(ctx, "key1", t0, 2)
+	assert.NoError(t, err)
+	_, err = inMem.IncrementByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+
+	// reading should not change the count
+	for i := 0; i < 2; i++ {
+		count, err = inMem.GetByKey(ctx, "key1", t0)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+	}
+
+	count, prevCount, err := inMem.GetWithPrevious(ctx, "key1", t1, t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 2, prevCount)
+
+	count, err = inMem.GetByKey(ctx, "key2", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestGetLog(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	inMem := repository.NewInMemRepository()
+
+	_, _, err := inMem.AppendLog(ctx, "key1", t0, t0.Add(-time.Minute), 2, 1)
+	assert.NoError(t, err)
+	_, _, err = inMem.AppendLog(ctx, "key1", t0.Add(time.Second), t0.Add(-59*time.Second), 2, 1)
+	assert.NoError(t, err)
+
+	log, err := inMem.GetLog(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{t0.Add(time.Second)}, log)
+
+	// reading should not prune the log
+	log, err = inMem.GetLog(ctx, "key1", t0.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{t0, t0.Add(time.Second)}, log)
+}
+
+func TestGetTokensAndEmptyAt(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	inMem := repository.NewInMemRepository()
+
+	// new bucket starts full
+	b, err := inMem.GetTokens(ctx, "key1", t0, time.Second, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: 2, LastRefill: t0}, b)
+
+	_, _, err = inMem.TakeTokens(ctx, "key1", t0, time.Second, 2, 2)
+	assert.NoError(t, err)
+	b, err = inMem.GetTokens(ctx, "key1", t0.Add(time.Second), time.Second, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: 1, LastRefill: t0.Add(time.Second)}, b)
+
+	// reading should not store the refill
+	b, _, err = inMem.TakeTokens(ctx, "key1", t0, time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: 0, LastRefill: t0}, b)
+
+	emptyAt, err := inMem.GetEmptyAt(ctx, "key1")
+	assert.NoError(t, err)
+	assert.True(t, emptyAt.IsZero())
+
+	_, _, err = inMem.Enqueue(ctx, "key1", t0, time.Second, 2, 2)
+	assert.NoError(t, err)
+	emptyAt, err = inMem.GetEmptyAt(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(2*time.Second), emptyAt)
+}
+
+func TestDecrementAndDeleteByKey(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	t1 := t0.Add(time.Minute)
+	inMem := repository.NewInMemRepository()
+
+	_, err := inMem.IncrementByKeyN(ctx, "key1", t0, 3)
+	assert.NoError(t, err)
+	_, err = inMem.IncrementByKeyN(ctx, "key1", t1, 1)
+	assert.NoError(t, err)
+
+	count, err := inMem.DecrementByKey(ctx, "key1", t0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// should not go below zero
+	count, err = inMem.DecrementByKey(ctx, "key1", t1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// untracked window should stay untracked
+	count, err = inMem.DecrementByKey(ctx, "key1", t1.Add(time.Minute), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = inMem.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	assert.NoError(t, inMem.DeleteByKey(ctx, "key1"))
+	count, err = inMem.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestPopAndDeleteLog(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	inMem := repository.NewInMemRepository()
+
+	_, _, err := inMem.AppendLog(ctx, "key1", t0, t0.Add(-time.Minute), 3, 1)
+	assert.NoError(t, err)
+	_, _, err = inMem.AppendLog(ctx, "key1", t0.Add(time.Second), t0.Add(-time.Minute), 3, 2)
+	assert.NoError(t, err)
+
+	assert.NoError(t, inMem.PopLog(ctx, "key1", 1))
+	log, err := inMem.GetLog(ctx, "key1", t0.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{t0, t0.Add(time.Second)}, log)
+
+	// should not pop more than the log has
+	assert.NoError(t, inMem.PopLog(ctx, "key1", 5))
+	log, err = inMem.GetLog(ctx, "key1", t0.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, log)
+
+	_, _, err = inMem.AppendLog(ctx, "key1", t0, t0.Add(-time.Minute), 3, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, inMem.DeleteLog(ctx, "key1"))
+	log, err = inMem.GetLog(ctx, "key1", t0.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, log)
+}
+
+func TestDequeue(t *testing.T) {
+	mockClock := clock.NewMock()
+	ctx := context.Background()
+	t0 := mockClock.Now()
+	inMem := repository.NewInMemRepository()
+
+	_, _, err := inMem.Enqueue(ctx, "key1", t0, time.Second, 3, 3)
+	assert.NoError(t, err)
+
+	emptyAt, err := inMem.Dequeue(ctx, "key1", t0, time.Second, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(2*time.Second), emptyAt)
+
+	// should not move before now
+	emptyAt, err = inMem.Dequeue(ctx, "key1", t0.Add(time.Second), time.Second, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(time.Second), emptyAt)
+
+	assert.NoError(t, inMem.DeleteQueue(ctx, "key1"))
+	emptyAt, err = inMem.GetEmptyAt(ctx, "key1")
+	assert.NoError(t, err)
+	assert.True(t, emptyAt.IsZero())
+}
+
+func TestInMemRepository_Janitor(t *testing.T) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	t0 := mockClock.Now()
+	t1 := t0.Add(time.Minute)
+
+	inMem := repository.NewInMemRepositoryWithOpts(repository.InMemOpts{
+		Expiration:      2 * time.Minute,
+		JanitorInterval: time.Minute,
+		Clock:           mockClock,
+	})
+	defer inMem.Close()
+
+	_, err := inMem.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	_, err = inMem.IncrementByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	_, _, err = inMem.AppendLog(ctx, "key1", t0, t0.Add(-time.Minute), 5, 1)
+	assert.NoError(t, err)
+	_, err = inMem.ReserveTokens(ctx, "key1", t0, time.Second, 5, 1)
+	assert.NoError(t, err)
+	_, _, err = inMem.Enqueue(ctx, "key1", t0, time.Minute, 5, 1)
+	assert.NoError(t, err)
+	_, err = inMem.CompareAndSetTimestamp(ctx, "key1", time.Time{}, t0.Add(time.Minute))
+	assert.NoError(t, err)
+
+	// the queue and timestamp expire at t1
+	mockClock.Add(time.Minute)
+	assert.Eventually(t, func() bool {
+		emptyAt, _ := inMem.GetEmptyAt(ctx, "key1")
+		tat, _ := inMem.GetTimestamp(ctx, "key1")
+		return emptyAt.IsZero() && tat.IsZero()
+	}, time.Second, time.Millisecond)
+	count, err := inMem.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// the rest of the state of t0 expires after the expiration
+	mockClock.Add(time.Minute)
+	assert.Eventually(t, func() bool {
+		count, _ := inMem.GetByKey(ctx, "key1", t0)
+		return count == 0
+	}, time.Second, time.Millisecond)
+	log, err := inMem.GetLog(ctx, "key1", time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, log)
+	b, err := inMem.GetTokens(ctx, "key1", t0, time.Second, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, b.Tokens)
+	count, err = inMem.GetByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// the janitor no longer runs after closing, which waits for it to
+	// stop
+	assert.NoError(t, inMem.Close())
+	assert.NoError(t, inMem.Close())
+	mockClock.Add(time.Minute)
+	count, err = inMem.GetByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestInMemRepository_MaxKeys(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	inMem := repository.NewInMemRepositoryWithOpts(repository.InMemOpts{MaxKeys: 2})
+
+	_, err := inMem.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	_, _, err = inMem.AppendLog(ctx, "key1", t0, t0.Add(-time.Minute), 5, 1)
+	assert.NoError(t, err)
+	_, err = inMem.IncrementByKey(ctx, "key2", t0)
+	assert.NoError(t, err)
+
+	// key1 is updated more recently than key2, so key2 is evicted
+	_, err = inMem.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	_, err = inMem.IncrementByKey(ctx, "key3", t0)
+	assert.NoError(t, err)
+
+	count, err := inMem.GetByKey(ctx, "key2", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = inMem.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// every state of the evicted key is removed
+	_, err = inMem.CompareAndSetTimestamp(ctx, "key4", time.Time{}, t0)
+	assert.NoError(t, err)
+	count, err = inMem.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	log, err := inMem.GetLog(ctx, "key1", time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, log)
+
+	// a deleted key does not take up room
+	assert.NoError(t, inMem.DeleteTimestamp(ctx, "key4"))
+	_, err = inMem.IncrementByKey(ctx, "key5", t0)
+	assert.NoError(t, err)
+	count, err = inMem.GetByKey(ctx, "key3", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,296 @@
+// Package memcache provides a repository implementation backed by
+// memcached, so that the rate limit of a key can be shared by multiple
+// instances of a service.
+package memcache
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gomemcache "github.com/bradfitz/gomemcache/memcache"
+	"github.com/pkg/errors"
+)
+
+// DefaultExpiration is the default of Opts.Expiration
+const DefaultExpiration = time.Hour
+
+// Opts stores the configuration options of Repository
+type Opts struct {
+	// KeyPrefix is prepended to every memcached key written by the
+	// repository, e.g. to share a memcached instance with other services.
+	KeyPrefix string
+
+	// Expiration is how long the request count of a window is kept after
+	// the window starts. Set it to the duration of the limiter for the
+	// counts to expire at the end of their window. Defaults to
+	// DefaultExpiration.
+	Expiration time.Duration
+}
+
+// Repository is repository implementation with memcached. The request
+// count of every key and window is kept in its own memcached item, which
+// is created with add and incremented with incr. The windows of a key are
+// also listed in an index item of the key, so that DeleteByKey can find
+// them whatever the duration of the limiter is.
+//
+// Note that memcached keys can not be longer than 250 bytes or contain
+// whitespace, so neither can the rate limit keys.
+type Repository struct {
+	client     *gomemcache.Client
+	keyPrefix  string
+	expiration time.Duration
+}
+
+// NewRepository returns a new instance of memcached repository. It takes
+// a client that has been configured by the caller. Example:
+//
+//   client := gomemcache.New("localhost:11211")
+//   repo := memcache.NewRepository(client, memcache.Opts{Expiration: time.Minute})
+//   rateLimiter := ratelimiter.NewFixedWindowRateLimiter(10, time.Minute, repo, clock)
+func NewRepository(client *gomemcache.Client, opts Opts) *Repository {
+	expiration := opts.Expiration
+	if expiration <= 0 {
+		expiration = DefaultExpiration
+	}
+	return &Repository{
+		client:     client,
+		keyPrefix:  opts.KeyPrefix,
+		expiration: expiration,
+	}
+}
+
+// IncrementByKey increases the request count for the given key and
+// window by 1
+func (r *Repository) IncrementByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	return r.IncrementByKeyN(ctx, key, window, 1)
+}
+
+// IncrementByKeyN increases the request count for the given key and
+// window by n. The count of a window that does not exist yet is created
+// with add, which only one process can win when several of them see the
+// cold key at the same time. The others increment the count created by
+// the winner, which also adds the window to the index of the key. A
+// negative n reverts a previous increment.
+func (r *Repository) IncrementByKeyN(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	if n < 0 {
+		return r.DecrementByKey(ctx, key, window, -n)
+	}
+
+	windowKey := r.windowKey(key, window)
+
+	for {
+		count, err := r.client.Increment(windowKey, uint64(n))
+		if err == nil {
+			return int(count), nil
+		}
+		if err != gomemcache.ErrCacheMiss {
+			return 0, errors.Wrap(err, "failed to increment memcache item")
+		}
+
+		err = r.client.Add(&gomemcache.Item{
+			Key:        windowKey,
+			Value:      []byte(strconv.Itoa(n)),
+			Expiration: r.expireAt(window),
+		})
+		if err == nil {
+			if err := r.index(ctx, key, window); err != nil {
+				return 0, err
+			}
+			return n, nil
+		}
+		if err != gomemcache.ErrNotStored {
+			return 0, errors.Wrap(err, "failed to add memcache item")
+		}
+
+		// another process has added the window first, increment it
+		// unless ctx is done
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// GetByKey returns the request count for the given key and window
+func (r *Repository) GetByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	windowKey := r.windowKey(key, window)
+
+	item, err := r.client.Get(windowKey)
+	if err == gomemcache.ErrCacheMiss {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get memcache item")
+	}
+
+	// decr pads the value with spaces when it has fewer digits than before
+	count, err := strconv.Atoi(strings.TrimRight(string(item.Value), " "))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse memcache item")
+	}
+	return count, nil
+}
+
+// DecrementByKey decreases the request count for the given key and
+// window by n. memcached never decrements below zero and does nothing if
+// the window does not exist.
+func (r *Repository) DecrementByKey(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	windowKey := r.windowKey(key, window)
+
+	count, err := r.client.Decrement(windowKey, uint64(n))
+	if err == gomemcache.ErrCacheMiss {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to decrement memcache item")
+	}
+	return int(count), nil
+}
+
+// DeleteByKey removes the request counts of every window of the given
+// key that is in its index. The index is updated with cas, so a window
+// that is added to it meanwhile is deleted too.
+//
+// Note that memcached may evict the index of a key like any other item,
+// in which case the windows of the key are left to expire.
+func (r *Repository) DeleteByKey(ctx context.Context, key string) error {
+	indexKey := r.indexKey(key)
+	for {
+		item, err := r.client.Get(indexKey)
+		if err == gomemcache.ErrCacheMiss {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to get memcache index")
+		}
+		windows, err := parseIndex(item.Value)
+		if err != nil {
+			return err
+		}
+		if len(windows) == 0 {
+			return nil
+		}
+
+		for _, w := range windows {
+			err := r.client.Delete(r.windowKey(key, w))
+			if err != nil && err != gomemcache.ErrCacheMiss {
+				return errors.Wrap(err, "failed to delete memcache item")
+			}
+		}
+
+		item.Value = nil
+		item.Expiration = r.expireAt(windows[len(windows)-1])
+		err = r.client.CompareAndSwap(item)
+		if err == nil || err == gomemcache.ErrCacheMiss {
+			return nil
+		}
+		if err != gomemcache.ErrCASConflict {
+			return errors.Wrap(err, "failed to update memcache index")
+		}
+
+		// a window has been added to the index meanwhile, delete it too
+		// unless ctx is done
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// index adds window to the index of the given key. The windows that have
+// expired by the start of window are dropped from it, so that the index
+// of a key that is never deleted does not keep growing. The index expires
+// along with the last of its windows.
+func (r *Repository) index(ctx context.Context, key string, window time.Time) error {
+	indexKey := r.indexKey(key)
+	for {
+		item, err := r.client.Get(indexKey)
+		switch err {
+		case gomemcache.ErrCacheMiss:
+			err = r.client.Add(&gomemcache.Item{
+				Key:        indexKey,
+				Value:      formatIndex([]time.Time{window}),
+				Expiration: r.expireAt(window),
+			})
+			if err != gomemcache.ErrNotStored {
+				return errors.Wrap(err, "failed to add memcache index")
+			}
+		case nil:
+			windows, err := parseIndex(item.Value)
+			if err != nil {
+				return err
+			}
+			kept := []time.Time{window}
+			for _, w := range windows {
+				if !w.Equal(window) && time.Unix(int64(r.expireAt(w)), 0).After(window) {
+					kept = append(kept, w)
+				}
+			}
+			sort.Slice(kept, func(i, j int) bool { return kept[i].Before(kept[j]) })
+
+			item.Value = formatIndex(kept)
+			item.Expiration = r.expireAt(kept[len(kept)-1])
+			err = r.client.CompareAndSwap(item)
+			if err != gomemcache.ErrCASConflict && err != gomemcache.ErrCacheMiss {
+				return errors.Wrap(err, "failed to update memcache index")
+			}
+		default:
+			return errors.Wrap(err, "failed to get memcache index")
+		}
+
+		// another process has changed the index first, try again unless
+		// ctx is done
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// indexKey returns the memcached key of the index of the given key, which
+// can not collide with a window key as those start with a number
+func (r *Repository) indexKey(key string) string {
+	return r.keyPrefix + "index:" + key
+}
+
+// formatIndex returns the value of an index of the given windows, which
+// are listed as Unix nanoseconds separated by spaces
+func formatIndex(windows []time.Time) []byte {
+	fields := make([]string, len(windows))
+	for i, w := range windows {
+		fields[i] = strconv.FormatInt(w.UnixNano(), 10)
+	}
+	return []byte(strings.Join(fields, " "))
+}
+
+// parseIndex returns the windows of the value of an index
+func parseIndex(value []byte) ([]time.Time, error) {
+	fields := strings.Fields(string(value))
+	windows := make([]time.Time, len(fields))
+	for i, field := range fields {
+		nanos, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse memcache index")
+		}
+		windows[i] = time.Unix(0, nanos)
+	}
+	return windows, nil
+}
+
+// windowKey returns the memcached key of the given key and window. The
+// window comes first, as it does not contain a colon, so that the
+// memcached keys of different keys can not collide.
+func (r *Repository) windowKey(key string, window time.Time) string {
+	return r.keyPrefix + strconv.FormatInt(window.UnixNano(), 10) + ":" + key
+}
+
+// expireAt returns the expiration of the given window as a Unix
+// timestamp, which memcached only has a precision of seconds for
+func (r *Repository) expireAt(window time.Time) int32 {
+	expireAt := window.Add(r.expiration)
+	secs := expireAt.Unix()
+	if expireAt.After(time.Unix(secs, 0)) {
+		secs++
+	}
+	return int32(secs)
+}
@@ -0,0 +1,141 @@
+package memcache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gomemcache "github.com/bradfitz/gomemcache/memcache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/memcache"
+	"github.com/yonasstephen/ratelimiter/repository/repositorytest"
+)
+
+func newTestRepository(t *testing.T, t0 time.Time, opts memcache.Opts) (*memcache.Repository, *fakeServer) {
+	s := newFakeServer(t, t0)
+	return memcache.NewRepository(gomemcache.New(s.Addr()), opts), s
+}
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		repo, _ := newTestRepository(t, repositorytest.T0, memcache.Opts{KeyPrefix: "ratelimit:"})
+		return repo
+	})
+}
+
+func TestIncrementByKey_Expiration(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	t1 := t0.Add(time.Minute)
+	repo, s := newTestRepository(t, t0, memcache.Opts{KeyPrefix: "ratelimit:", Expiration: time.Minute})
+
+	_, err := repo.IncrementByKeyN(ctx, "key1", t0, 3)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ratelimit:1600000000000000000:key1", "ratelimit:index:key1"}, s.Keys())
+	_, err = repo.IncrementByKey(ctx, "key1", t1)
+	require.NoError(t, err)
+
+	// the window expires at its end
+	s.Add(time.Minute)
+	count, err := repo.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = repo.GetByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestIncrementByKey_ColdKeyRace(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, s := newTestRepository(t, t0, memcache.Opts{})
+
+	// another process adds the window right after the key is found to be
+	// cold, so the add fails and the increment has to be retried
+	other := memcache.NewRepository(gomemcache.New(s.Addr()), memcache.Opts{})
+	raced := false
+	s.beforeAdd = func(key string) {
+		if !raced {
+			raced = true
+			_, err := other.IncrementByKeyN(ctx, "key1", t0, 2)
+			require.NoError(t, err)
+		}
+	}
+
+	count, err := repo.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestDecrementByKey_Padding(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, _ := newTestRepository(t, t0, memcache.Opts{})
+
+	_, err := repo.IncrementByKeyN(ctx, "key1", t0, 12)
+	require.NoError(t, err)
+
+	// decr pads 9 with a space to the length of 12
+	count, err := repo.DecrementByKey(ctx, "key1", t0, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, count)
+	count, err = repo.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, count)
+	count, err = repo.IncrementByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, count)
+}
+
+func TestDeleteByKey_Index(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, s := newTestRepository(t, t0, memcache.Opts{Expiration: time.Minute})
+	client := gomemcache.New(s.Addr())
+
+	// the windows that have expired are dropped from the index
+	for i := 0; i < 3; i++ {
+		_, err := repo.IncrementByKey(ctx, "key1", t0.Add(time.Duration(i)*time.Minute))
+		require.NoError(t, err)
+	}
+	_, err := repo.IncrementByKey(ctx, "key1", t0.Add(150*time.Second))
+	require.NoError(t, err)
+	item, err := client.Get("index:key1")
+	require.NoError(t, err)
+	assert.Equal(t, "1600000120000000000 1600000150000000000", string(item.Value))
+
+	// a window that is added to the index while the key is deleted is
+	// deleted too
+	raced := false
+	s.beforeCAS = func(key string) {
+		if !raced {
+			raced = true
+			_, err := repo.IncrementByKey(ctx, "key1", t0.Add(3*time.Minute))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, repo.DeleteByKey(ctx, "key1"))
+	assert.True(t, raced)
+	count, err := repo.GetByKey(ctx, "key1", t0.Add(3*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	item, err = client.Get("index:key1")
+	require.NoError(t, err)
+	assert.Empty(t, item.Value)
+}
+
+func TestRepository_ConnectionError(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, s := newTestRepository(t, t0, memcache.Opts{})
+	s.listener.Close()
+
+	_, err := repo.IncrementByKey(ctx, "key1", t0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to increment memcache item")
+
+	assert.Error(t, repo.DeleteByKey(ctx, "key1"))
+}
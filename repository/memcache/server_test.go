@@ -0,0 +1,222 @@
+package memcache_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is an in-process memcached that speaks the subset of the
+// text protocol used by the repository
+type fakeServer struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	now   time.Time
+	items map[string]fakeItem
+	cas   uint64
+
+	// beforeAdd is called before an add command is handled, e.g. to race
+	// it with another client
+	beforeAdd func(key string)
+
+	// beforeCAS is called before a cas command is handled
+	beforeCAS func(key string)
+}
+
+type fakeItem struct {
+	value    string
+	expireAt time.Time
+	cas      uint64
+}
+
+func newFakeServer(t *testing.T, now time.Time) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		listener: l,
+		now:      now,
+		items:    map[string]fakeItem{},
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		l.Close()
+	})
+	return s
+}
+
+func (s *fakeServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Add moves the time of the server forward by d
+func (s *fakeServer) Add(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(d)
+}
+
+// Keys returns the keys of the items that have not expired
+func (s *fakeServer) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.items {
+		if _, ok := s.get(k); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch cmd := fields[0]; cmd {
+		case "get", "gets":
+			s.mu.Lock()
+			for _, k := range fields[1:] {
+				if item, ok := s.get(k); ok {
+					fmt.Fprintf(rw, "VALUE %s 0 %d %d\r\n%s\r\n", k, len(item.value), item.cas, item.value)
+				}
+			}
+			s.mu.Unlock()
+			fmt.Fprint(rw, "END\r\n")
+		case "set", "add", "cas":
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, data); err != nil {
+				return
+			}
+			exp, _ := strconv.ParseInt(fields[3], 10, 64)
+			if cmd == "add" && s.beforeAdd != nil {
+				s.beforeAdd(fields[1])
+			}
+			var cas uint64
+			if cmd == "cas" {
+				cas, _ = strconv.ParseUint(fields[5], 10, 64)
+				if s.beforeCAS != nil {
+					s.beforeCAS(fields[1])
+				}
+			}
+			fmt.Fprint(rw, s.store(cmd, fields[1], string(data[:size]), exp, cas))
+		case "incr", "decr":
+			delta, _ := strconv.ParseUint(fields[2], 10, 64)
+			fmt.Fprint(rw, s.incrDecr(cmd, fields[1], delta))
+		case "delete":
+			s.mu.Lock()
+			if _, ok := s.get(fields[1]); ok {
+				delete(s.items, fields[1])
+				fmt.Fprint(rw, "DELETED\r\n")
+			} else {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+			}
+			s.mu.Unlock()
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// get must be called while holding the lock
+func (s *fakeServer) get(key string) (fakeItem, bool) {
+	item, ok := s.items[key]
+	if !ok {
+		return fakeItem{}, false
+	}
+	if !item.expireAt.IsZero() && !s.now.Before(item.expireAt) {
+		delete(s.items, key)
+		return fakeItem{}, false
+	}
+	return item, true
+}
+
+func (s *fakeServer) store(cmd, key, value string, exp int64, cas uint64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.get(key)
+	switch {
+	case ok && cmd == "add":
+		return "NOT_STORED\r\n"
+	case !ok && cmd == "cas":
+		return "NOT_FOUND\r\n"
+	case ok && cmd == "cas" && item.cas != cas:
+		return "EXISTS\r\n"
+	}
+
+	// expirations of up to 30 days are relative, otherwise absolute
+	var expireAt time.Time
+	switch {
+	case exp > 30*24*60*60:
+		expireAt = time.Unix(exp, 0)
+	case exp > 0:
+		expireAt = s.now.Add(time.Duration(exp) * time.Second)
+	}
+	s.cas++
+	s.items[key] = fakeItem{value: value, expireAt: expireAt, cas: s.cas}
+	return "STORED\r\n"
+}
+
+func (s *fakeServer) incrDecr(cmd, key string, delta uint64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.get(key)
+	if !ok {
+		return "NOT_FOUND\r\n"
+	}
+	value, err := strconv.ParseUint(strings.TrimRight(item.value, " "), 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+	}
+
+	if cmd == "incr" {
+		value += delta
+	} else if delta > value {
+		value = 0
+	} else {
+		value -= delta
+	}
+
+	// like memcached, decr keeps the length of the value by padding it
+	// with spaces
+	newValue := strconv.FormatUint(value, 10)
+	if cmd == "decr" && len(newValue) < len(item.value) {
+		newValue += strings.Repeat(" ", len(item.value)-len(newValue))
+	}
+	item.value = newValue
+	s.cas++
+	item.cas = s.cas
+	s.items[key] = item
+	return strconv.FormatUint(value, 10) + "\r\n"
+}
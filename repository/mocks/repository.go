@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/yonasstephen/ratelimiter/repository (interfaces: Repository)
+// Source: github.com/yonasstephen/ratelimiter/repository (interfaces: Repository,LogRepository,SlidingWindowRepository,SlidingWindowLimitRepository,TokenBucketRepository,LeakyBucketRepository,TimestampRepository)
 
 // Package mocks is a generated GoMock package.
 package mocks
@@ -10,6 +10,7 @@ import (
 	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	repository "github.com/yonasstephen/ratelimiter/repository"
 )
 
 // MockRepository is a mock of Repository interface.
@@ -35,6 +36,50 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// DecrementByKey mocks base method.
+func (m *MockRepository) DecrementByKey(arg0 context.Context, arg1 string, arg2 time.Time, arg3 int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecrementByKey", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecrementByKey indicates an expected call of DecrementByKey.
+func (mr *MockRepositoryMockRecorder) DecrementByKey(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecrementByKey", reflect.TypeOf((*MockRepository)(nil).DecrementByKey), arg0, arg1, arg2, arg3)
+}
+
+// DeleteByKey mocks base method.
+func (m *MockRepository) DeleteByKey(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteByKey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteByKey indicates an expected call of DeleteByKey.
+func (mr *MockRepositoryMockRecorder) DeleteByKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteByKey", reflect.TypeOf((*MockRepository)(nil).DeleteByKey), arg0, arg1)
+}
+
+// GetByKey mocks base method.
+func (m *MockRepository) GetByKey(arg0 context.Context, arg1 string, arg2 time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByKey", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByKey indicates an expected call of GetByKey.
+func (mr *MockRepositoryMockRecorder) GetByKey(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByKey", reflect.TypeOf((*MockRepository)(nil).GetByKey), arg0, arg1, arg2)
+}
+
 // IncrementByKey mocks base method.
 func (m *MockRepository) IncrementByKey(arg0 context.Context, arg1 string, arg2 time.Time) (int, error) {
 	m.ctrl.T.Helper()
@@ -49,3 +94,533 @@ func (mr *MockRepositoryMockRecorder) IncrementByKey(arg0, arg1, arg2 interface{
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementByKey", reflect.TypeOf((*MockRepository)(nil).IncrementByKey), arg0, arg1, arg2)
 }
+
+// IncrementByKeyN mocks base method.
+func (m *MockRepository) IncrementByKeyN(arg0 context.Context, arg1 string, arg2 time.Time, arg3 int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementByKeyN", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IncrementByKeyN indicates an expected call of IncrementByKeyN.
+func (mr *MockRepositoryMockRecorder) IncrementByKeyN(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementByKeyN", reflect.TypeOf((*MockRepository)(nil).IncrementByKeyN), arg0, arg1, arg2, arg3)
+}
+
+// MockLogRepository is a mock of LogRepository interface.
+type MockLogRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockLogRepositoryMockRecorder
+}
+
+// MockLogRepositoryMockRecorder is the mock recorder for MockLogRepository.
+type MockLogRepositoryMockRecorder struct {
+	mock *MockLogRepository
+}
+
+// NewMockLogRepository creates a new mock instance.
+func NewMockLogRepository(ctrl *gomock.Controller) *MockLogRepository {
+	mock := &MockLogRepository{ctrl: ctrl}
+	mock.recorder = &MockLogRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLogRepository) EXPECT() *MockLogRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AppendLog mocks base method.
+func (m *MockLogRepository) AppendLog(arg0 context.Context, arg1 string, arg2, arg3 time.Time, arg4, arg5 int) ([]time.Time, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AppendLog", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].([]time.Time)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// AppendLog indicates an expected call of AppendLog.
+func (mr *MockLogRepositoryMockRecorder) AppendLog(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendLog", reflect.TypeOf((*MockLogRepository)(nil).AppendLog), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// DeleteLog mocks base method.
+func (m *MockLogRepository) DeleteLog(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteLog", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteLog indicates an expected call of DeleteLog.
+func (mr *MockLogRepositoryMockRecorder) DeleteLog(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteLog", reflect.TypeOf((*MockLogRepository)(nil).DeleteLog), arg0, arg1)
+}
+
+// GetLog mocks base method.
+func (m *MockLogRepository) GetLog(arg0 context.Context, arg1 string, arg2 time.Time) ([]time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLog", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLog indicates an expected call of GetLog.
+func (mr *MockLogRepositoryMockRecorder) GetLog(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLog", reflect.TypeOf((*MockLogRepository)(nil).GetLog), arg0, arg1, arg2)
+}
+
+// PopLog mocks base method.
+func (m *MockLogRepository) PopLog(arg0 context.Context, arg1 string, arg2 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PopLog", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PopLog indicates an expected call of PopLog.
+func (mr *MockLogRepositoryMockRecorder) PopLog(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PopLog", reflect.TypeOf((*MockLogRepository)(nil).PopLog), arg0, arg1, arg2)
+}
+
+// MockSlidingWindowRepository is a mock of SlidingWindowRepository interface.
+type MockSlidingWindowRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockSlidingWindowRepositoryMockRecorder
+}
+
+// MockSlidingWindowRepositoryMockRecorder is the mock recorder for MockSlidingWindowRepository.
+type MockSlidingWindowRepositoryMockRecorder struct {
+	mock *MockSlidingWindowRepository
+}
+
+// NewMockSlidingWindowRepository creates a new mock instance.
+func NewMockSlidingWindowRepository(ctrl *gomock.Controller) *MockSlidingWindowRepository {
+	mock := &MockSlidingWindowRepository{ctrl: ctrl}
+	mock.recorder = &MockSlidingWindowRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSlidingWindowRepository) EXPECT() *MockSlidingWindowRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DecrementByKey mocks base method.
+func (m *MockSlidingWindowRepository) DecrementByKey(arg0 context.Context, arg1 string, arg2 time.Time, arg3 int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecrementByKey", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecrementByKey indicates an expected call of DecrementByKey.
+func (mr *MockSlidingWindowRepositoryMockRecorder) DecrementByKey(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecrementByKey", reflect.TypeOf((*MockSlidingWindowRepository)(nil).DecrementByKey), arg0, arg1, arg2, arg3)
+}
+
+// DeleteByKey mocks base method.
+func (m *MockSlidingWindowRepository) DeleteByKey(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteByKey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteByKey indicates an expected call of DeleteByKey.
+func (mr *MockSlidingWindowRepositoryMockRecorder) DeleteByKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteByKey", reflect.TypeOf((*MockSlidingWindowRepository)(nil).DeleteByKey), arg0, arg1)
+}
+
+// GetWithPrevious mocks base method.
+func (m *MockSlidingWindowRepository) GetWithPrevious(arg0 context.Context, arg1 string, arg2, arg3 time.Time) (int, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithPrevious", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetWithPrevious indicates an expected call of GetWithPrevious.
+func (mr *MockSlidingWindowRepositoryMockRecorder) GetWithPrevious(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithPrevious", reflect.TypeOf((*MockSlidingWindowRepository)(nil).GetWithPrevious), arg0, arg1, arg2, arg3)
+}
+
+// IncrementWithPrevious mocks base method.
+func (m *MockSlidingWindowRepository) IncrementWithPrevious(arg0 context.Context, arg1 string, arg2, arg3 time.Time, arg4 int) (int, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementWithPrevious", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// IncrementWithPrevious indicates an expected call of IncrementWithPrevious.
+func (mr *MockSlidingWindowRepositoryMockRecorder) IncrementWithPrevious(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementWithPrevious", reflect.TypeOf((*MockSlidingWindowRepository)(nil).IncrementWithPrevious), arg0, arg1, arg2, arg3, arg4)
+}
+
+// MockSlidingWindowLimitRepository is a mock of SlidingWindowLimitRepository interface.
+type MockSlidingWindowLimitRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockSlidingWindowLimitRepositoryMockRecorder
+}
+
+// MockSlidingWindowLimitRepositoryMockRecorder is the mock recorder for MockSlidingWindowLimitRepository.
+type MockSlidingWindowLimitRepositoryMockRecorder struct {
+	mock *MockSlidingWindowLimitRepository
+}
+
+// NewMockSlidingWindowLimitRepository creates a new mock instance.
+func NewMockSlidingWindowLimitRepository(ctrl *gomock.Controller) *MockSlidingWindowLimitRepository {
+	mock := &MockSlidingWindowLimitRepository{ctrl: ctrl}
+	mock.recorder = &MockSlidingWindowLimitRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSlidingWindowLimitRepository) EXPECT() *MockSlidingWindowLimitRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DecrementByKey mocks base method.
+func (m *MockSlidingWindowLimitRepository) DecrementByKey(arg0 context.Context, arg1 string, arg2 time.Time, arg3 int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecrementByKey", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecrementByKey indicates an expected call of DecrementByKey.
+func (mr *MockSlidingWindowLimitRepositoryMockRecorder) DecrementByKey(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecrementByKey", reflect.TypeOf((*MockSlidingWindowLimitRepository)(nil).DecrementByKey), arg0, arg1, arg2, arg3)
+}
+
+// DeleteByKey mocks base method.
+func (m *MockSlidingWindowLimitRepository) DeleteByKey(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteByKey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteByKey indicates an expected call of DeleteByKey.
+func (mr *MockSlidingWindowLimitRepositoryMockRecorder) DeleteByKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteByKey", reflect.TypeOf((*MockSlidingWindowLimitRepository)(nil).DeleteByKey), arg0, arg1)
+}
+
+// GetWithPrevious mocks base method.
+func (m *MockSlidingWindowLimitRepository) GetWithPrevious(arg0 context.Context, arg1 string, arg2, arg3 time.Time) (int, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithPrevious", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetWithPrevious indicates an expected call of GetWithPrevious.
+func (mr *MockSlidingWindowLimitRepositoryMockRecorder) GetWithPrevious(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithPrevious", reflect.TypeOf((*MockSlidingWindowLimitRepository)(nil).GetWithPrevious), arg0, arg1, arg2, arg3)
+}
+
+// IncrementWithPrevious mocks base method.
+func (m *MockSlidingWindowLimitRepository) IncrementWithPrevious(arg0 context.Context, arg1 string, arg2, arg3 time.Time, arg4 int) (int, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementWithPrevious", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// IncrementWithPrevious indicates an expected call of IncrementWithPrevious.
+func (mr *MockSlidingWindowLimitRepositoryMockRecorder) IncrementWithPrevious(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementWithPrevious", reflect.TypeOf((*MockSlidingWindowLimitRepository)(nil).IncrementWithPrevious), arg0, arg1, arg2, arg3, arg4)
+}
+
+// IncrementWithinLimit mocks base method.
+func (m *MockSlidingWindowLimitRepository) IncrementWithinLimit(arg0 context.Context, arg1 string, arg2, arg3, arg4 time.Time, arg5, arg6 int) (int, int, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementWithinLimit", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(bool)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+// IncrementWithinLimit indicates an expected call of IncrementWithinLimit.
+func (mr *MockSlidingWindowLimitRepositoryMockRecorder) IncrementWithinLimit(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementWithinLimit", reflect.TypeOf((*MockSlidingWindowLimitRepository)(nil).IncrementWithinLimit), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
+// MockTokenBucketRepository is a mock of TokenBucketRepository interface.
+type MockTokenBucketRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockTokenBucketRepositoryMockRecorder
+}
+
+// MockTokenBucketRepositoryMockRecorder is the mock recorder for MockTokenBucketRepository.
+type MockTokenBucketRepositoryMockRecorder struct {
+	mock *MockTokenBucketRepository
+}
+
+// NewMockTokenBucketRepository creates a new mock instance.
+func NewMockTokenBucketRepository(ctrl *gomock.Controller) *MockTokenBucketRepository {
+	mock := &MockTokenBucketRepository{ctrl: ctrl}
+	mock.recorder = &MockTokenBucketRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTokenBucketRepository) EXPECT() *MockTokenBucketRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DeleteTokens mocks base method.
+func (m *MockTokenBucketRepository) DeleteTokens(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteTokens", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteTokens indicates an expected call of DeleteTokens.
+func (mr *MockTokenBucketRepositoryMockRecorder) DeleteTokens(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTokens", reflect.TypeOf((*MockTokenBucketRepository)(nil).DeleteTokens), arg0, arg1)
+}
+
+// GetTokens mocks base method.
+func (m *MockTokenBucketRepository) GetTokens(arg0 context.Context, arg1 string, arg2 time.Time, arg3 time.Duration, arg4 int) (repository.TokenBucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTokens", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(repository.TokenBucket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTokens indicates an expected call of GetTokens.
+func (mr *MockTokenBucketRepositoryMockRecorder) GetTokens(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTokens", reflect.TypeOf((*MockTokenBucketRepository)(nil).GetTokens), arg0, arg1, arg2, arg3, arg4)
+}
+
+// PutTokens mocks base method.
+func (m *MockTokenBucketRepository) PutTokens(arg0 context.Context, arg1 string, arg2 time.Time, arg3 time.Duration, arg4, arg5 int) (repository.TokenBucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PutTokens", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(repository.TokenBucket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PutTokens indicates an expected call of PutTokens.
+func (mr *MockTokenBucketRepositoryMockRecorder) PutTokens(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutTokens", reflect.TypeOf((*MockTokenBucketRepository)(nil).PutTokens), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// ReserveTokens mocks base method.
+func (m *MockTokenBucketRepository) ReserveTokens(arg0 context.Context, arg1 string, arg2 time.Time, arg3 time.Duration, arg4, arg5 int) (repository.TokenBucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveTokens", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(repository.TokenBucket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReserveTokens indicates an expected call of ReserveTokens.
+func (mr *MockTokenBucketRepositoryMockRecorder) ReserveTokens(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveTokens", reflect.TypeOf((*MockTokenBucketRepository)(nil).ReserveTokens), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// TakeTokens mocks base method.
+func (m *MockTokenBucketRepository) TakeTokens(arg0 context.Context, arg1 string, arg2 time.Time, arg3 time.Duration, arg4, arg5 int) (repository.TokenBucket, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TakeTokens", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(repository.TokenBucket)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// TakeTokens indicates an expected call of TakeTokens.
+func (mr *MockTokenBucketRepositoryMockRecorder) TakeTokens(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TakeTokens", reflect.TypeOf((*MockTokenBucketRepository)(nil).TakeTokens), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// MockLeakyBucketRepository is a mock of LeakyBucketRepository interface.
+type MockLeakyBucketRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockLeakyBucketRepositoryMockRecorder
+}
+
+// MockLeakyBucketRepositoryMockRecorder is the mock recorder for MockLeakyBucketRepository.
+type MockLeakyBucketRepositoryMockRecorder struct {
+	mock *MockLeakyBucketRepository
+}
+
+// NewMockLeakyBucketRepository creates a new mock instance.
+func NewMockLeakyBucketRepository(ctrl *gomock.Controller) *MockLeakyBucketRepository {
+	mock := &MockLeakyBucketRepository{ctrl: ctrl}
+	mock.recorder = &MockLeakyBucketRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLeakyBucketRepository) EXPECT() *MockLeakyBucketRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DeleteQueue mocks base method.
+func (m *MockLeakyBucketRepository) DeleteQueue(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteQueue", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteQueue indicates an expected call of DeleteQueue.
+func (mr *MockLeakyBucketRepositoryMockRecorder) DeleteQueue(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteQueue", reflect.TypeOf((*MockLeakyBucketRepository)(nil).DeleteQueue), arg0, arg1)
+}
+
+// Dequeue mocks base method.
+func (m *MockLeakyBucketRepository) Dequeue(arg0 context.Context, arg1 string, arg2 time.Time, arg3 time.Duration, arg4 int) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Dequeue", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Dequeue indicates an expected call of Dequeue.
+func (mr *MockLeakyBucketRepositoryMockRecorder) Dequeue(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dequeue", reflect.TypeOf((*MockLeakyBucketRepository)(nil).Dequeue), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Enqueue mocks base method.
+func (m *MockLeakyBucketRepository) Enqueue(arg0 context.Context, arg1 string, arg2 time.Time, arg3 time.Duration, arg4, arg5 int) (time.Time, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enqueue", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Enqueue indicates an expected call of Enqueue.
+func (mr *MockLeakyBucketRepositoryMockRecorder) Enqueue(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enqueue", reflect.TypeOf((*MockLeakyBucketRepository)(nil).Enqueue), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// GetEmptyAt mocks base method.
+func (m *MockLeakyBucketRepository) GetEmptyAt(arg0 context.Context, arg1 string) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEmptyAt", arg0, arg1)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEmptyAt indicates an expected call of GetEmptyAt.
+func (mr *MockLeakyBucketRepositoryMockRecorder) GetEmptyAt(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEmptyAt", reflect.TypeOf((*MockLeakyBucketRepository)(nil).GetEmptyAt), arg0, arg1)
+}
+
+// MockTimestampRepository is a mock of TimestampRepository interface.
+type MockTimestampRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimestampRepositoryMockRecorder
+}
+
+// MockTimestampRepositoryMockRecorder is the mock recorder for MockTimestampRepository.
+type MockTimestampRepositoryMockRecorder struct {
+	mock *MockTimestampRepository
+}
+
+// NewMockTimestampRepository creates a new mock instance.
+func NewMockTimestampRepository(ctrl *gomock.Controller) *MockTimestampRepository {
+	mock := &MockTimestampRepository{ctrl: ctrl}
+	mock.recorder = &MockTimestampRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTimestampRepository) EXPECT() *MockTimestampRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CompareAndSetTimestamp mocks base method.
+func (m *MockTimestampRepository) CompareAndSetTimestamp(arg0 context.Context, arg1 string, arg2, arg3 time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompareAndSetTimestamp", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CompareAndSetTimestamp indicates an expected call of CompareAndSetTimestamp.
+func (mr *MockTimestampRepositoryMockRecorder) CompareAndSetTimestamp(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareAndSetTimestamp", reflect.TypeOf((*MockTimestampRepository)(nil).CompareAndSetTimestamp), arg0, arg1, arg2, arg3)
+}
+
+// DeleteTimestamp mocks base method.
+func (m *MockTimestampRepository) DeleteTimestamp(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteTimestamp", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteTimestamp indicates an expected call of DeleteTimestamp.
+func (mr *MockTimestampRepositoryMockRecorder) DeleteTimestamp(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTimestamp", reflect.TypeOf((*MockTimestampRepository)(nil).DeleteTimestamp), arg0, arg1)
+}
+
+// GetTimestamp mocks base method.
+func (m *MockTimestampRepository) GetTimestamp(arg0 context.Context, arg1 string) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTimestamp", arg0, arg1)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTimestamp indicates an expected call of GetTimestamp.
+func (mr *MockTimestampRepositoryMockRecorder) GetTimestamp(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTimestamp", reflect.TypeOf((*MockTimestampRepository)(nil).GetTimestamp), arg0, arg1)
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// Enqueue adds n requests to the queue of the given key if they fit. It
+// runs as a Lua script, so concurrent requests of the key can not
+// overflow the queue.
+func (r *Repository) Enqueue(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (time.Time, bool, error) {
+	keys := []string{r.queueKey(key)}
+	res, err := enqueueScript.Run(ctx, r.client, keys, toMicros(now), durationMicros(interval), capacity, n).Int64Slice()
+	if err != nil {
+		return time.Time{}, false, errors.Wrap(err, "failed to enqueue redis queue")
+	}
+	return fromMicros(res[0]), res[1] == 1, nil
+}
+
+// GetEmptyAt returns the time when the queue of the given key will be
+// empty
+func (r *Repository) GetEmptyAt(ctx context.Context, key string) (time.Time, error) {
+	us, err := r.client.Get(ctx, r.queueKey(key)).Int64()
+	if err == goredis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to get redis queue")
+	}
+	return fromMicros(us), nil
+}
+
+// Dequeue moves the time when the queue of the given key will be empty
+// back by n intervals, but not before now
+func (r *Repository) Dequeue(ctx context.Context, key string, now time.Time, interval time.Duration, n int) (time.Time, error) {
+	keys := []string{r.queueKey(key)}
+	us, err := dequeueScript.Run(ctx, r.client, keys, toMicros(now), durationMicros(interval), n).Int64()
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to dequeue redis queue")
+	}
+	return fromMicros(us), nil
+}
+
+// DeleteQueue removes the queue of the given key
+func (r *Repository) DeleteQueue(ctx context.Context, key string) error {
+	return errors.Wrap(r.client.Del(ctx, r.queueKey(key)).Err(), "failed to delete redis queue")
+}
+
+// queueKey returns the Redis key of the queue of the given key
+func (r *Repository) queueKey(key string) string {
+	return r.keyPrefix + "{" + key + "}:queue"
+}
@@ -0,0 +1,88 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository/redis"
+)
+
+func TestEnqueue(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, m := newTestRepository(t, t0, redis.Opts{})
+
+	// empty queue drains the request after an interval
+	emptyAt, ok, err := repo.Enqueue(ctx, "key1", t0, time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(time.Second), emptyAt)
+
+	emptyAt, ok, err = repo.Enqueue(ctx, "key1", t0, time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(2*time.Second), emptyAt)
+
+	// the queue expires once it is empty
+	assert.Equal(t, 2*time.Second, m.TTL("{key1}:queue"))
+
+	// key1 is full
+	emptyAt, ok, err = repo.Enqueue(ctx, "key1", t0.Add(500*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, t0.Add(2*time.Second), emptyAt)
+
+	// key1 has drained a request
+	emptyAt, ok, err = repo.Enqueue(ctx, "key1", t0.Add(time.Second), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(3*time.Second), emptyAt)
+
+	emptyAt, err = repo.GetEmptyAt(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(3*time.Second), emptyAt)
+
+	emptyAt, err = repo.Dequeue(ctx, "key1", t0.Add(time.Second), time.Second, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(2*time.Second), emptyAt)
+
+	// should not move before now
+	emptyAt, err = repo.Dequeue(ctx, "key1", t0.Add(time.Second), time.Second, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(time.Second), emptyAt)
+	assert.False(t, m.Exists("{key1}:queue"))
+
+	_, _, err = repo.Enqueue(ctx, "key1", t0, time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.DeleteQueue(ctx, "key1"))
+	emptyAt, err = repo.GetEmptyAt(ctx, "key1")
+	assert.NoError(t, err)
+	assert.True(t, emptyAt.IsZero())
+}
+
+func TestLeakyBucketRateLimiter_SharedAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	repo, _ := newTestRepository(t, mockClock.Now(), redis.Opts{})
+
+	r1 := ratelimiter.NewLeakyBucketRateLimiter(1, time.Second, 2, repo, mockClock)
+	r2 := ratelimiter.NewLeakyBucketRateLimiter(1, time.Second, 2, repo, mockClock)
+
+	res, err := r1.Allow(ctx, "user_123")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+	res, err = r2.Allow(ctx, "user_123")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+
+	res, err = r1.Allow(ctx, "user_123")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 0, RetryAfter: time.Second, ResetAfter: 2 * time.Second}, res)
+}
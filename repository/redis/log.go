@@ -0,0 +1,78 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AppendLog removes the timestamps of the given key that are not after
+// since and appends now n times to the log if there is room for them.
+// It runs as a Lua script, so concurrent requests of the key can not
+// overshoot the limit.
+func (r *Repository) AppendLog(ctx context.Context, key string, now, since time.Time, limit, n int) ([]time.Time, bool, error) {
+	res, err := appendLogScript.Run(ctx, r.client, []string{r.logKey(key)}, toMicros(now), toMicros(since), limit, n).Slice()
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to append redis log")
+	}
+
+	appended, _ := res[0].(int64)
+	entries, _ := res[1].([]interface{})
+	log, err := parseLog(entries)
+	if err != nil {
+		return nil, false, err
+	}
+	return log, appended == 1, nil
+}
+
+// GetLog returns the timestamps of the given key that are after since
+func (r *Repository) GetLog(ctx context.Context, key string, since time.Time) ([]time.Time, error) {
+	entries, err := r.client.LRange(ctx, r.logKey(key), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get redis log")
+	}
+
+	log := make([]time.Time, 0, len(entries))
+	for _, entry := range entries {
+		us, err := strconv.ParseInt(entry, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse redis log")
+		}
+		if ts := fromMicros(us); ts.After(since) {
+			log = append(log, ts)
+		}
+	}
+	return log, nil
+}
+
+// PopLog removes up to n of the newest timestamps of the given key
+func (r *Repository) PopLog(ctx context.Context, key string, n int) error {
+	err := r.client.LTrim(ctx, r.logKey(key), 0, int64(-n-1)).Err()
+	return errors.Wrap(err, "failed to pop redis log")
+}
+
+// DeleteLog removes the log of the given key
+func (r *Repository) DeleteLog(ctx context.Context, key string) error {
+	return errors.Wrap(r.client.Del(ctx, r.logKey(key)).Err(), "failed to delete redis log")
+}
+
+// logKey returns the Redis key of the log of the given key
+func (r *Repository) logKey(key string) string {
+	return r.keyPrefix + "{" + key + "}:log"
+}
+
+// parseLog parses the timestamps of a log returned by a script
+func parseLog(entries []interface{}) ([]time.Time, error) {
+	log := make([]time.Time, len(entries))
+	for i, entry := range entries {
+		s, _ := entry.(string)
+		us, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse redis log")
+		}
+		log[i] = fromMicros(us)
+	}
+	return log, nil
+}
@@ -0,0 +1,95 @@
+package redis_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository/redis"
+)
+
+func TestAppendLog(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, m := newTestRepository(t, t0, redis.Opts{})
+
+	// append to key1 until the limit is reached
+	log, appended, err := repo.AppendLog(ctx, "key1", t0, t0.Add(-time.Minute), 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, appended)
+	assert.Equal(t, []time.Time{t0}, log)
+
+	log, appended, err = repo.AppendLog(ctx, "key1", t0.Add(time.Second), t0.Add(-59*time.Second), 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, appended)
+	assert.Equal(t, []time.Time{t0, t0.Add(time.Second)}, log)
+
+	// key1 is full, should not append
+	log, appended, err = repo.AppendLog(ctx, "key1", t0.Add(2*time.Second), t0.Add(-58*time.Second), 2, 1)
+	assert.NoError(t, err)
+	assert.False(t, appended)
+	assert.Equal(t, []time.Time{t0, t0.Add(time.Second)}, log)
+
+	// key2 has its own log
+	log, appended, err = repo.AppendLog(ctx, "key2", t0.Add(2*time.Second), t0.Add(-58*time.Second), 2, 2)
+	assert.NoError(t, err)
+	assert.True(t, appended)
+	assert.Equal(t, []time.Time{t0.Add(2 * time.Second), t0.Add(2 * time.Second)}, log)
+
+	// t0 is no longer after since, should be pruned to make room
+	log, appended, err = repo.AppendLog(ctx, "key1", t0.Add(time.Minute), t0, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, appended)
+	assert.Equal(t, []time.Time{t0.Add(time.Second), t0.Add(time.Minute)}, log)
+
+	// the log expires once its newest timestamp is out of the duration
+	assert.Equal(t, 2*time.Minute, m.TTL("{key1}:log"))
+
+	log, err = repo.GetLog(ctx, "key1", t0.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{t0.Add(time.Minute)}, log)
+
+	assert.NoError(t, repo.PopLog(ctx, "key1", 1))
+	log, err = repo.GetLog(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{t0.Add(time.Second)}, log)
+
+	assert.NoError(t, repo.DeleteLog(ctx, "key1"))
+	log, err = repo.GetLog(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Empty(t, log)
+}
+
+func TestSlidingWindowLogRateLimiter_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	repo, _ := newTestRepository(t, mockClock.Now(), redis.Opts{})
+
+	// 10 instances of a service race for the same limit
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		r := ratelimiter.NewSlidingWindowLogRateLimiter(25, time.Minute, repo, mockClock)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				res, err := r.Allow(ctx, "user_123")
+				require.NoError(t, err)
+				mu.Lock()
+				allowed += res.Allowed
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 25, allowed)
+}
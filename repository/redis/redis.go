@@ -0,0 +1,242 @@
+// Package redis provides repository implementations backed by Redis, so
+// that the rate limit of a key can be shared by multiple instances of a
+// service.
+package redis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// DefaultExpiration is the default of Opts.Expiration
+const DefaultExpiration = time.Hour
+
+// Opts stores the configuration options of Repository
+type Opts struct {
+	// KeyPrefix is prepended to every Redis key written by the repository,
+	// e.g. to share a Redis instance with other services. It must not
+	// contain a hash tag i.e. curly braces.
+	KeyPrefix string
+
+	// Expiration is the time from the start of a window at which its
+	// Redis key is expired with PEXPIREAT, see repository.Repository for
+	// how long it needs to be. Defaults to DefaultExpiration.
+	Expiration time.Duration
+}
+
+// Repository is repository implementation with Redis. It implements
+// every repository interface, so it can back any of the rate limiters.
+//
+// The request count of every key and window is kept in its own Redis key,
+// which is incremented with INCRBY and expired with PEXPIREAT in a
+// transaction. The other algorithms keep their state in a Redis key per
+// key that is updated by a Lua script. Keys are wrapped in a hash tag, so
+// that all of the state of a key is stored in the same slot of a Redis
+// cluster.
+type Repository struct {
+	client     goredis.UniversalClient
+	keyPrefix  string
+	expiration time.Duration
+}
+
+// NewRepository returns a new instance of Redis repository. It takes a
+// client that has been configured by the caller, which can be a single
+// node, sentinel, or cluster client. Example:
+//
+//   client := goredis.NewClient(&goredis.Options{Addr: "localhost:6379"})
+//   repo := redis.NewRepository(client, redis.Opts{KeyPrefix: "ratelimit:"})
+func NewRepository(client goredis.UniversalClient, opts Opts) *Repository {
+	expiration := opts.Expiration
+	if expiration <= 0 {
+		expiration = DefaultExpiration
+	}
+	return &Repository{
+		client:     client,
+		keyPrefix:  opts.KeyPrefix,
+		expiration: expiration,
+	}
+}
+
+// decrementScript decreases the count of a window without going below
+// zero, and without creating a window that does not exist
+var decrementScript = goredis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local count = redis.call('DECRBY', KEYS[1], ARGV[1])
+if count < 0 then
+	redis.call('INCRBY', KEYS[1], -count)
+	count = 0
+end
+return count
+`)
+
+// IncrementByKey increases the request count for the given key and
+// window by 1
+func (r *Repository) IncrementByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	return r.IncrementByKeyN(ctx, key, window, 1)
+}
+
+// IncrementByKeyN increases the request count for the given key and
+// window by n and sets the Redis key to expire once the window is no
+// longer needed
+func (r *Repository) IncrementByKeyN(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	windowKey := r.windowKey(key, window)
+	var incr *goredis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		incr = pipe.IncrBy(ctx, windowKey, int64(n))
+		pipe.PExpireAt(ctx, windowKey, window.Add(r.expiration))
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to increment redis key")
+	}
+	return int(incr.Val()), nil
+}
+
+// GetByKey returns the request count for the given key and window
+func (r *Repository) GetByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	count, err := r.client.Get(ctx, r.windowKey(key, window)).Int()
+	if err == goredis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get redis key")
+	}
+	return count, nil
+}
+
+// DecrementByKey decreases the request count for the given key and
+// window by n, but not below zero
+func (r *Repository) DecrementByKey(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	count, err := decrementScript.Run(ctx, r.client, []string{r.windowKey(key, window)}, n).Int()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to decrement redis key")
+	}
+	return count, nil
+}
+
+// DeleteByKey removes the request counts of every window of the given
+// key. The windows are looked up with SCAN, so it is meant for
+// administrative use rather than for every request.
+func (r *Repository) DeleteByKey(ctx context.Context, key string) error {
+	prefix := r.keyPrefix + "{" + key + "}:"
+	err := r.scan(ctx, escapePattern(prefix)+"*", func(client goredis.UniversalClient, keys []string) error {
+		var windowKeys []string
+		for _, k := range keys {
+			// the pattern also matches the keys of the other algorithms
+			if _, err := strconv.ParseInt(strings.TrimPrefix(k, prefix), 10, 64); err == nil {
+				windowKeys = append(windowKeys, k)
+			}
+		}
+		if len(windowKeys) == 0 {
+			return nil
+		}
+		return client.Del(ctx, windowKeys...).Err()
+	})
+	return errors.Wrap(err, "failed to delete redis keys")
+}
+
+// IncrementWithPrevious increases the request count for the given key
+// and window by n just like IncrementByKeyN. It also returns the count
+// of prevWindow in the same transaction.
+func (r *Repository) IncrementWithPrevious(ctx context.Context, key string, window, prevWindow time.Time, n int) (int, int, error) {
+	windowKey := r.windowKey(key, window)
+	var incr *goredis.IntCmd
+	var prev *goredis.StringCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		incr = pipe.IncrBy(ctx, windowKey, int64(n))
+		pipe.PExpireAt(ctx, windowKey, window.Add(r.expiration))
+		prev = pipe.Get(ctx, r.windowKey(key, prevWindow))
+		return nil
+	})
+	if err != nil && err != goredis.Nil {
+		return 0, 0, errors.Wrap(err, "failed to increment redis key")
+	}
+	// a previous window that does not exist has a zero count
+	prevCount, _ := prev.Int()
+	return int(incr.Val()), prevCount, nil
+}
+
+// IncrementWithinLimit increases the request count for the given key and
+// window by n if the weighted count stays within limit. The counts are
+// checked and incremented by a Lua script, so a rejected request needs no
+// other round trip to revert its increment.
+func (r *Repository) IncrementWithinLimit(ctx context.Context, key string, now, window, prevWindow time.Time, limit, n int) (int, int, bool, error) {
+	keys := []string{r.windowKey(key, window), r.windowKey(key, prevWindow)}
+	expireAt := window.Add(r.expiration).UnixNano() / int64(time.Millisecond)
+	res, err := incrementWithinLimitScript.Run(ctx, r.client, keys, int64(now.Sub(window)), int64(window.Sub(prevWindow)), limit, n, expireAt).Int64Slice()
+	if err != nil {
+		return 0, 0, false, errors.Wrap(err, "failed to increment redis key")
+	}
+	return int(res[0]), int(res[1]), res[2] == 1, nil
+}
+
+// GetWithPrevious returns the request counts for the given key of window
+// and prevWindow
+func (r *Repository) GetWithPrevious(ctx context.Context, key string, window, prevWindow time.Time) (int, int, error) {
+	vals, err := r.client.MGet(ctx, r.windowKey(key, window), r.windowKey(key, prevWindow)).Result()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get redis keys")
+	}
+
+	var counts [2]int
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			counts[i], _ = strconv.Atoi(s)
+		}
+	}
+	return counts[0], counts[1], nil
+}
+
+// windowKey returns the Redis key of the given key and window
+func (r *Repository) windowKey(key string, window time.Time) string {
+	return r.keyPrefix + "{" + key + "}:" + strconv.FormatInt(window.UnixNano(), 10)
+}
+
+// scan calls fn with every batch of Redis keys that match pattern, along
+// with the client of the node that holds them. Every master node is
+// scanned for a cluster client.
+func (r *Repository) scan(ctx context.Context, pattern string, fn func(client goredis.UniversalClient, keys []string) error) error {
+	scanNode := func(ctx context.Context, client goredis.UniversalClient) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, pattern, 100).Result()
+			if err != nil {
+				return err
+			}
+			if err := fn(client, keys); err != nil {
+				return err
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+
+	if cluster, ok := r.client.(*goredis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *goredis.Client) error {
+			return scanNode(ctx, client)
+		})
+	}
+	return scanNode(ctx, r.client)
+}
+
+// escapePattern escapes the glob characters of s for SCAN MATCH
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,183 @@
+package redis_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/benbjohnson/clock"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/redis"
+	"github.com/yonasstephen/ratelimiter/repository/repositorytest"
+)
+
+// newTestRepository returns a repository backed by an in-process Redis
+// whose time is set to t0
+func newTestRepository(t *testing.T, t0 time.Time, opts redis.Opts) (*redis.Repository, *miniredis.Miniredis) {
+	m := miniredis.RunT(t)
+	m.SetTime(t0)
+	client := goredis.NewClient(&goredis.Options{Addr: m.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return redis.NewRepository(client, opts), m
+}
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		repo, _ := newTestRepository(t, repositorytest.T0, redis.Opts{KeyPrefix: "ratelimit:"})
+		return repo
+	})
+}
+
+func TestIncrementByKey_Expiration(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	t1 := t0.Add(time.Minute)
+	repo, m := newTestRepository(t, t0, redis.Opts{KeyPrefix: "ratelimit:", Expiration: 2 * time.Minute})
+
+	_, err := repo.IncrementByKeyN(ctx, "key1", t0, 3)
+	require.NoError(t, err)
+	_, err = repo.IncrementByKey(ctx, "key1", t1)
+	require.NoError(t, err)
+
+	// the window expires after the configured expiration
+	windowKey := "ratelimit:{key1}:1600000000000000000"
+	assert.True(t, m.Exists(windowKey))
+	assert.Equal(t, 2*time.Minute, m.TTL(windowKey))
+	m.FastForward(2 * time.Minute)
+	count, err := repo.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = repo.GetByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestDeleteByKey_HashTag(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, m := newTestRepository(t, t0, redis.Opts{})
+
+	_, err := repo.IncrementByKey(ctx, "key1", t0)
+	require.NoError(t, err)
+	_, err = repo.IncrementByKey(ctx, "key1", t0.Add(time.Minute))
+	require.NoError(t, err)
+	_, err = repo.IncrementByKey(ctx, "key1:a", t0)
+	require.NoError(t, err)
+
+	// the windows of a key share its hash tag, which the windows of
+	// another key never match
+	assert.NoError(t, repo.DeleteByKey(ctx, "key1"))
+	assert.Equal(t, []string{"{key1:a}:1600000000000000000"}, m.Keys())
+}
+
+func TestIncrementWithPrevious(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	t1 := t0.Add(time.Minute)
+	repo, _ := newTestRepository(t, t0, redis.Opts{})
+
+	// there is no previous window yet
+	count, prevCount, err := repo.IncrementWithPrevious(ctx, "key1", t0, t0.Add(-time.Minute), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 0, prevCount)
+
+	count, prevCount, err = repo.IncrementWithPrevious(ctx, "key1", t1, t0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 2, prevCount)
+
+	count, prevCount, err = repo.GetWithPrevious(ctx, "key1", t1, t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 2, prevCount)
+}
+
+func TestIncrementWithinLimit(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	t1 := t0.Add(time.Minute)
+	repo, m := newTestRepository(t, t0, redis.Opts{Expiration: 2 * time.Minute})
+
+	_, err := repo.IncrementByKeyN(ctx, "key1", t0, 8)
+	require.NoError(t, err)
+
+	// halfway through t1, the previous window weighs 8*0.5
+	now := t1.Add(30 * time.Second)
+	count, prevCount, ok, err := repo.IncrementWithinLimit(ctx, "key1", now, t1, t0, 10, 6)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 6, count)
+	assert.Equal(t, 8, prevCount)
+	assert.Equal(t, 3*time.Minute, m.TTL("{key1}:1600000060000000000"))
+
+	// a request that does not fit is not counted
+	count, prevCount, ok, err = repo.IncrementWithinLimit(ctx, "key1", now, t1, t0, 10, 1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 6, count)
+	assert.Equal(t, 8, prevCount)
+	count, err = repo.GetByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+
+	// it fits once the previous window has decayed to 8*0.375
+	count, _, ok, err = repo.IncrementWithinLimit(ctx, "key1", now.Add(7500*time.Millisecond), t1, t0, 10, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 7, count)
+}
+
+func TestSlidingWindowCounterRateLimiter_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, _ := newTestRepository(t, t0, redis.Opts{})
+	mockClock := clock.NewMock()
+	mockClock.Set(t0)
+	r := ratelimiter.NewSlidingWindowCounterRateLimiter(10, time.Minute, repo, mockClock)
+
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := r.Allow(ctx, "key1")
+			assert.NoError(t, err)
+			atomic.AddInt64(&allowed, int64(res.Allowed))
+		}()
+	}
+	wg.Wait()
+
+	// the rejected requests have never been counted
+	assert.Equal(t, int64(10), allowed)
+	count, err := repo.GetByKey(ctx, "key1", t0.Truncate(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 10, count)
+}
+
+func TestRepository_ConnectionError(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, m := newTestRepository(t, t0, redis.Opts{})
+	m.Close()
+
+	_, err := repo.IncrementByKey(ctx, "key1", t0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to increment redis key")
+
+	_, err = repo.GetByKey(ctx, "key1", t0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get redis key")
+}
+
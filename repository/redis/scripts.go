@@ -0,0 +1,241 @@
+package redis
+
+import (
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// The algorithms that need to read and modify their state are run as Lua
+// scripts, so that every operation is atomic across the processes sharing
+// the Redis instance. goredis.Script runs a script with EVALSHA and falls
+// back to EVAL when Redis replies with NOSCRIPT, e.g. after a restart, so
+// a script is only sent in full once per Redis instance.
+//
+// Lua numbers are doubles, hence times are passed to the scripts as
+// microseconds since the Unix epoch, and numbers are written back with
+// string.format('%d') as Redis would otherwise format them in scientific
+// notation.
+
+// appendLogScript removes the timestamps that are not after since from
+// the log, then appends now n times to it if it has room for them. The
+// log expires once its newest timestamp is no longer after since.
+//
+// KEYS: log
+// ARGV: now, since, limit, n
+// Returns: {appended, log}
+var appendLogScript = goredis.NewScript(`
+local now = tonumber(ARGV[1])
+local since = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local log = redis.call('LRANGE', KEYS[1], 0, -1)
+local expired = 0
+for i, ts in ipairs(log) do
+	if tonumber(ts) > since then
+		break
+	end
+	expired = i
+end
+if expired > 0 then
+	redis.call('LTRIM', KEYS[1], expired, -1)
+end
+
+local appended = 0
+if #log - expired + n <= limit then
+	for i = 1, n do
+		redis.call('RPUSH', KEYS[1], ARGV[1])
+	end
+	appended = 1
+end
+
+local newest = redis.call('LINDEX', KEYS[1], -1)
+if newest then
+	local expireAt = math.ceil((tonumber(newest) + now - since) / 1000)
+	redis.call('PEXPIREAT', KEYS[1], string.format('%d', expireAt))
+end
+return {appended, redis.call('LRANGE', KEYS[1], 0, -1)}
+`)
+
+// incrementWithinLimitScript increments the count of the window by n if
+// the count of the previous window, weighted by how much of it still
+// overlaps the trailing duration, plus the count of the window and n is
+// within limit. The estimate is computed in the same order as
+// ratelimiter.SlidingWindowCounterRateLimiter does, and the durations are
+// passed as nanoseconds, which doubles hold exactly.
+//
+// KEYS: window, previous window
+// ARGV: elapsed, duration, limit, n, expireAt in milliseconds
+// Returns: {count, prevCount, ok}
+var incrementWithinLimitScript = goredis.NewScript(`
+local elapsed = tonumber(ARGV[1])
+local duration = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local count = tonumber(redis.call('GET', KEYS[1]) or 0)
+local prevCount = tonumber(redis.call('GET', KEYS[2]) or 0)
+if prevCount * (duration - elapsed) / duration + (count + n) > limit then
+	return {count, prevCount, 0}
+end
+
+count = redis.call('INCRBY', KEYS[1], n)
+redis.call('PEXPIREAT', KEYS[1], ARGV[5])
+return {count, prevCount, 1}
+`)
+
+// token bucket operations of tokenBucketScript
+const (
+	tokenBucketGet     = "get"
+	tokenBucketTake    = "take"
+	tokenBucketReserve = "reserve"
+	tokenBucketPut     = "put"
+)
+
+// tokenBucketScript refills the bucket in the same way as
+// repository.TokenBucket.Refill, then runs the given operation on it. The
+// bucket is removed once it is full, as a bucket that does not exist
+// starts full, and otherwise expires when it would be full again.
+//
+// KEYS: bucket
+// ARGV: operation, now, interval, capacity, n
+// Returns: {tokens, lastRefill, ok}
+var tokenBucketScript = goredis.NewScript(`
+local op = ARGV[1]
+local now = tonumber(ARGV[2])
+local interval = tonumber(ARGV[3])
+local capacity = tonumber(ARGV[4])
+local n = tonumber(ARGV[5])
+
+local tokens, last = capacity, now
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+if bucket[1] then
+	tokens = tonumber(bucket[1])
+	last = tonumber(bucket[2])
+end
+
+if tokens >= capacity then
+	tokens, last = capacity, now
+elseif now - last >= interval then
+	local refill = math.floor((now - last) / interval)
+	if tokens + refill >= capacity then
+		tokens, last = capacity, now
+	else
+		tokens, last = tokens + refill, last + refill * interval
+	end
+end
+
+local ok = 1
+if op == 'take' then
+	if tokens >= n then
+		tokens = tokens - n
+	else
+		ok = 0
+	end
+elseif op == 'reserve' then
+	tokens = tokens - n
+elseif op == 'put' then
+	tokens = tokens + n
+	if tokens >= capacity then
+		tokens, last = capacity, now
+	end
+end
+
+if op ~= 'get' then
+	if tokens >= capacity then
+		redis.call('DEL', KEYS[1])
+	else
+		redis.call('HSET', KEYS[1], 'tokens', string.format('%d', tokens), 'last', string.format('%d', last))
+		local fullAt = math.ceil((last + (capacity - tokens) * interval) / 1000)
+		redis.call('PEXPIREAT', KEYS[1], string.format('%d', fullAt))
+	end
+end
+return {tokens, last, ok}
+`)
+
+// enqueueScript adds n requests to the queue if they fit. The queue is
+// tracked by the time when it will be empty, which is also when it
+// expires.
+//
+// KEYS: queue
+// ARGV: now, interval, capacity, n
+// Returns: {emptyAt, ok}
+var enqueueScript = goredis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local emptyAt = tonumber(redis.call('GET', KEYS[1]) or 0)
+if emptyAt < now then
+	emptyAt = now
+end
+
+local next = emptyAt + n * interval
+if next - now > capacity * interval then
+	return {emptyAt, 0}
+end
+redis.call('SET', KEYS[1], string.format('%d', next))
+redis.call('PEXPIREAT', KEYS[1], string.format('%d', math.ceil(next / 1000)))
+return {next, 1}
+`)
+
+// dequeueScript moves the time when the queue will be empty back by n
+// intervals, but not before now
+//
+// KEYS: queue
+// ARGV: now, interval, n
+// Returns: emptyAt
+var dequeueScript = goredis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local emptyAt = tonumber(redis.call('GET', KEYS[1]) or 0) - n * interval
+if emptyAt <= now then
+	redis.call('DEL', KEYS[1])
+	return now
+end
+redis.call('SET', KEYS[1], string.format('%d', emptyAt))
+redis.call('PEXPIREAT', KEYS[1], string.format('%d', math.ceil(emptyAt / 1000)))
+return emptyAt
+`)
+
+// compareAndSetScript sets the timestamp to value if it is equal to
+// expected, where a timestamp that does not exist is equal to 0. The
+// timestamp expires at value.
+//
+// KEYS: timestamp
+// ARGV: expected, value
+// Returns: whether the timestamp has been set
+var compareAndSetScript = goredis.NewScript(`
+if (redis.call('GET', KEYS[1]) or '0') ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+redis.call('PEXPIREAT', KEYS[1], string.format('%d', math.ceil(tonumber(ARGV[2]) / 1000)))
+return 1
+`)
+
+// toMicros returns t as microseconds since the Unix epoch, or 0 for a
+// zero value time
+func toMicros(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Microsecond)
+}
+
+// fromMicros is the reverse of toMicros
+func fromMicros(us int64) time.Time {
+	if us == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, us*int64(time.Microsecond))
+}
+
+// durationMicros returns d as microseconds
+func durationMicros(d time.Duration) int64 {
+	return int64(d / time.Microsecond)
+}
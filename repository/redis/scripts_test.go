@@ -0,0 +1,32 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter/repository/redis"
+)
+
+func TestScripts_NoScriptFallback(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, m := newTestRepository(t, t0, redis.Opts{})
+
+	_, _, err := repo.TakeTokens(ctx, "key1", t0, time.Second, 2, 1)
+	require.NoError(t, err)
+
+	// scripts are gone e.g. after Redis restarts, they should be loaded
+	// again
+	client := goredis.NewClient(&goredis.Options{Addr: m.Addr()})
+	defer client.Close()
+	require.NoError(t, client.ScriptFlush(ctx).Err())
+
+	_, taken, err := repo.TakeTokens(ctx, "key1", t0, time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+}
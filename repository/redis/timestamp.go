@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// GetTimestamp returns the timestamp of the given key
+func (r *Repository) GetTimestamp(ctx context.Context, key string) (time.Time, error) {
+	us, err := r.client.Get(ctx, r.timestampKey(key)).Int64()
+	if err == goredis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to get redis timestamp")
+	}
+	return fromMicros(us), nil
+}
+
+// CompareAndSetTimestamp sets the timestamp of the given key to value if
+// it has not been changed from expected. It runs as a Lua script, so it
+// is atomic across the processes sharing the Redis instance. The
+// timestamp expires at value.
+func (r *Repository) CompareAndSetTimestamp(ctx context.Context, key string, expected, value time.Time) (bool, error) {
+	keys := []string{r.timestampKey(key)}
+	ok, err := compareAndSetScript.Run(ctx, r.client, keys, toMicros(expected), toMicros(value)).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to set redis timestamp")
+	}
+	return ok == 1, nil
+}
+
+// DeleteTimestamp removes the timestamp of the given key
+func (r *Repository) DeleteTimestamp(ctx context.Context, key string) error {
+	return errors.Wrap(r.client.Del(ctx, r.timestampKey(key)).Err(), "failed to delete redis timestamp")
+}
+
+// timestampKey returns the Redis key of the timestamp of the given key
+func (r *Repository) timestampKey(key string) string {
+	return r.keyPrefix + "{" + key + "}:tat"
+}
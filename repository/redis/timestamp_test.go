@@ -0,0 +1,79 @@
+package redis_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository/redis"
+)
+
+func TestCompareAndSetTimestamp(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, m := newTestRepository(t, t0, redis.Opts{})
+
+	// key1 does not exist yet
+	ts, err := repo.GetTimestamp(ctx, "key1")
+	assert.NoError(t, err)
+	assert.True(t, ts.IsZero())
+
+	ok, err := repo.CompareAndSetTimestamp(ctx, "key1", time.Time{}, t0.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// key1 is no longer zero value
+	ok, err = repo.CompareAndSetTimestamp(ctx, "key1", time.Time{}, t0.Add(2*time.Second))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = repo.CompareAndSetTimestamp(ctx, "key1", t0.Add(time.Second), t0.Add(2*time.Second))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ts, err = repo.GetTimestamp(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(2*time.Second), ts)
+
+	// the timestamp expires at its value
+	assert.Equal(t, 2*time.Second, m.TTL("{key1}:tat"))
+
+	assert.NoError(t, repo.DeleteTimestamp(ctx, "key1"))
+	ts, err = repo.GetTimestamp(ctx, "key1")
+	assert.NoError(t, err)
+	assert.True(t, ts.IsZero())
+}
+
+func TestGCRARateLimiter_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	repo, _ := newTestRepository(t, mockClock.Now(), redis.Opts{})
+
+	// 10 instances of a service race for the same limit
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		r := ratelimiter.NewGCRARateLimiter(25, time.Minute, repo, mockClock)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				res, err := r.Allow(ctx, "user_123")
+				require.NoError(t, err)
+				mu.Lock()
+				allowed += res.Allowed
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 25, allowed)
+}
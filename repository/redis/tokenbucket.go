@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+// TakeTokens refills the bucket of the given key and takes n tokens out
+// of it if there are enough. It runs as a Lua script, so concurrent
+// requests of the key can not take the same tokens.
+func (r *Repository) TakeTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (repository.TokenBucket, bool, error) {
+	return r.runTokenBucket(ctx, tokenBucketTake, key, now, interval, capacity, n)
+}
+
+// ReserveTokens refills the bucket of the given key and takes n tokens
+// out of it, going into debt if there are not enough
+func (r *Repository) ReserveTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (repository.TokenBucket, error) {
+	b, _, err := r.runTokenBucket(ctx, tokenBucketReserve, key, now, interval, capacity, n)
+	return b, err
+}
+
+// PutTokens refills the bucket of the given key and puts n tokens back
+// into it, up to capacity
+func (r *Repository) PutTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (repository.TokenBucket, error) {
+	b, _, err := r.runTokenBucket(ctx, tokenBucketPut, key, now, interval, capacity, n)
+	return b, err
+}
+
+// GetTokens returns the refilled bucket of the given key without storing
+// it
+func (r *Repository) GetTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity int) (repository.TokenBucket, error) {
+	b, _, err := r.runTokenBucket(ctx, tokenBucketGet, key, now, interval, capacity, 0)
+	return b, err
+}
+
+// DeleteTokens removes the bucket of the given key
+func (r *Repository) DeleteTokens(ctx context.Context, key string) error {
+	return errors.Wrap(r.client.Del(ctx, r.bucketKey(key)).Err(), "failed to delete redis bucket")
+}
+
+// runTokenBucket runs the given operation of tokenBucketScript
+func (r *Repository) runTokenBucket(ctx context.Context, op, key string, now time.Time, interval time.Duration, capacity, n int) (repository.TokenBucket, bool, error) {
+	keys := []string{r.bucketKey(key)}
+	res, err := tokenBucketScript.Run(ctx, r.client, keys, op, toMicros(now), durationMicros(interval), capacity, n).Int64Slice()
+	if err != nil {
+		return repository.TokenBucket{}, false, errors.Wrap(err, "failed to run redis token bucket")
+	}
+
+	b := repository.TokenBucket{
+		Tokens:     int(res[0]),
+		LastRefill: fromMicros(res[1]),
+	}
+	return b, res[2] == 1, nil
+}
+
+// bucketKey returns the Redis key of the token bucket of the given key
+func (r *Repository) bucketKey(key string) string {
+	return r.keyPrefix + "{" + key + "}:bucket"
+}
@@ -0,0 +1,105 @@
+package redis_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/redis"
+)
+
+func TestTakeTokens(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, m := newTestRepository(t, t0, redis.Opts{})
+
+	// new bucket starts full
+	b, taken, err := repo.TakeTokens(ctx, "key1", t0, time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 1, LastRefill: t0}, b)
+
+	// the bucket expires when it would be full again
+	assert.Equal(t, time.Second, m.TTL("{key1}:bucket"))
+
+	b, taken, err = repo.TakeTokens(ctx, "key1", t0.Add(500*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 0, LastRefill: t0}, b)
+
+	// key1 is empty
+	b, taken, err = repo.TakeTokens(ctx, "key1", t0.Add(900*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.False(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 0, LastRefill: t0}, b)
+
+	// key2 has its own bucket
+	b, taken, err = repo.TakeTokens(ctx, "key2", t0.Add(900*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 1, LastRefill: t0.Add(900 * time.Millisecond)}, b)
+
+	// 1 token is refilled, the partial interval carries over to the next refill
+	b, taken, err = repo.TakeTokens(ctx, "key1", t0.Add(1500*time.Millisecond), time.Second, 2, 1)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, repository.TokenBucket{Tokens: 0, LastRefill: t0.Add(time.Second)}, b)
+
+	// reading should not store the refill
+	b, err = repo.GetTokens(ctx, "key1", t0.Add(5*time.Second), time.Second, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: 2, LastRefill: t0.Add(5 * time.Second)}, b)
+
+	// the bucket goes into debt and is paid back up to capacity
+	b, err = repo.ReserveTokens(ctx, "key1", t0.Add(1500*time.Millisecond), time.Second, 2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: -2, LastRefill: t0.Add(time.Second)}, b)
+
+	b, err = repo.PutTokens(ctx, "key1", t0.Add(1500*time.Millisecond), time.Second, 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: 1, LastRefill: t0.Add(time.Second)}, b)
+
+	// full bucket is the same as a bucket that does not exist
+	b, err = repo.PutTokens(ctx, "key1", t0.Add(1500*time.Millisecond), time.Second, 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, repository.TokenBucket{Tokens: 2, LastRefill: t0.Add(1500 * time.Millisecond)}, b)
+	assert.False(t, m.Exists("{key1}:bucket"))
+
+	assert.NoError(t, repo.DeleteTokens(ctx, "key2"))
+	assert.False(t, m.Exists("{key2}:bucket"))
+}
+
+func TestTokenBucketRateLimiter_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	repo, _ := newTestRepository(t, mockClock.Now(), redis.Opts{})
+
+	// 10 instances of a service race for the same bucket
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		r := ratelimiter.NewTokenBucketRateLimiter(1, time.Second, 25, repo, mockClock)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				res, err := r.Allow(ctx, "user_123")
+				require.NoError(t, err)
+				mu.Lock()
+				allowed += res.Allowed
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 25, allowed)
+}
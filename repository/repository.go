@@ -1,6 +1,6 @@
 package repository
 
-//go:generate mockgen -package=mocks -destination=mocks/repository.go github.com/yonasstephen/ratelimiter/repository Repository
+//go:generate mockgen -package=mocks -destination=mocks/repository.go github.com/yonasstephen/ratelimiter/repository Repository,LogRepository,SlidingWindowRepository,SlidingWindowLimitRepository,TokenBucketRepository,LeakyBucketRepository,TimestampRepository
 
 import (
 	"context"
@@ -8,7 +8,161 @@ import (
 )
 
 // Repository interfaces the interaction with the underlying
-// store where the rate limit data is persisted
+// store where the rate limit data is persisted.
+//
+// A store that expires the state of the keys has to keep it for longer
+// than the duration of the limiters using it, or twice as long for the
+// sliding window counter, which also reads the previous window.
 type Repository interface {
+	// IncrementByKey increases the request count for the given key and
+	// window by 1 and returns the count after the operation.
 	IncrementByKey(ctx context.Context, key string, window time.Time) (int, error)
+
+	// IncrementByKeyN increases the request count for the given key and
+	// window by n and returns the count after the operation. A negative n
+	// reverts a previous increment.
+	IncrementByKeyN(ctx context.Context, key string, window time.Time, n int) (int, error)
+
+	// GetByKey returns the request count for the given key and window
+	// without changing it. Zero is returned if the window is not tracked.
+	GetByKey(ctx context.Context, key string, window time.Time) (int, error)
+
+	// DecrementByKey decreases the request count for the given key and
+	// window by n, but not below zero, and returns the count after the
+	// operation. It does nothing if the window is not tracked.
+	DecrementByKey(ctx context.Context, key string, window time.Time, n int) (int, error)
+
+	// DeleteByKey removes the request counts of every window of the given
+	// key.
+	DeleteByKey(ctx context.Context, key string) error
+}
+
+// LogRepository interfaces the interaction with the underlying
+// store where the request timestamps of a rate limit log are persisted
+type LogRepository interface {
+	// AppendLog removes the timestamps of the given key that are not after
+	// since, then appends now n times to the log if it has room for them
+	// within limit timestamps. It returns the timestamps in the log ordered
+	// from the oldest and whether now has been appended.
+	AppendLog(ctx context.Context, key string, now, since time.Time, limit, n int) ([]time.Time, bool, error)
+
+	// GetLog returns the timestamps of the given key that are after since
+	// ordered from the oldest, without changing the log.
+	GetLog(ctx context.Context, key string, since time.Time) ([]time.Time, error)
+
+	// PopLog removes up to n of the newest timestamps of the given key.
+	PopLog(ctx context.Context, key string, n int) error
+
+	// DeleteLog removes the log of the given key.
+	DeleteLog(ctx context.Context, key string) error
+}
+
+// SlidingWindowRepository interfaces the interaction with the underlying
+// store where the request counts of the current and previous time windows
+// are persisted
+type SlidingWindowRepository interface {
+	// IncrementWithPrevious increases the request count for the given key
+	// and window by n. It returns the count of the window along with the
+	// count of prevWindow which is zero if prevWindow is not tracked.
+	IncrementWithPrevious(ctx context.Context, key string, window, prevWindow time.Time, n int) (int, int, error)
+
+	// GetWithPrevious returns the request counts for the given key of
+	// window and prevWindow without changing them. Zero is returned for a
+	// window that is not tracked.
+	GetWithPrevious(ctx context.Context, key string, window, prevWindow time.Time) (int, int, error)
+
+	// DecrementByKey decreases the request count for the given key and
+	// window like Repository.DecrementByKey.
+	DecrementByKey(ctx context.Context, key string, window time.Time, n int) (int, error)
+
+	// DeleteByKey removes the request counts of every window of the given
+	// key.
+	DeleteByKey(ctx context.Context, key string) error
+}
+
+// SlidingWindowLimitRepository is a SlidingWindowRepository that can also
+// check the limit and increment in one operation, which
+// ratelimiter.SlidingWindowCounterRateLimiter prefers so that it does not
+// need to revert the increment of a rejected request
+type SlidingWindowLimitRepository interface {
+	SlidingWindowRepository
+
+	// IncrementWithinLimit increases the request count for the given key
+	// and window by n if the weighted count stays within limit. The count
+	// of prevWindow is weighted by how much of it still overlaps the
+	// trailing duration at now, where the duration is the time between
+	// prevWindow and window. It returns the counts of window and
+	// prevWindow after the operation and whether the count has been
+	// increased.
+	IncrementWithinLimit(ctx context.Context, key string, now, window, prevWindow time.Time, limit, n int) (int, int, bool, error)
+}
+
+// TokenBucketRepository interfaces the interaction with the underlying
+// store where the state of token buckets are persisted
+type TokenBucketRepository interface {
+	// TakeTokens refills the bucket of the given key with a token for every
+	// interval that has elapsed since its last refill, up to capacity, then
+	// takes n tokens out of it if there are enough. A bucket that does not
+	// exist yet starts full. It returns the state of the bucket after the
+	// operation and whether the tokens have been taken.
+	TakeTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (TokenBucket, bool, error)
+
+	// ReserveTokens refills the bucket of the given key like TakeTokens,
+	// then takes n tokens out of it even if there are not enough, in which
+	// case the bucket goes into debt with negative tokens. It returns the
+	// state of the bucket after the operation.
+	ReserveTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (TokenBucket, error)
+
+	// PutTokens refills the bucket of the given key like TakeTokens, then
+	// puts n tokens back into it, up to capacity. It returns the state of
+	// the bucket after the operation.
+	PutTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (TokenBucket, error)
+
+	// GetTokens returns the state of the bucket of the given key as if it
+	// was refilled at now like TakeTokens, without changing it.
+	GetTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity int) (TokenBucket, error)
+
+	// DeleteTokens removes the bucket of the given key, so that it starts
+	// full again.
+	DeleteTokens(ctx context.Context, key string) error
+}
+
+// LeakyBucketRepository interfaces the interaction with the underlying
+// store where the queues of leaky buckets are persisted
+type LeakyBucketRepository interface {
+	// Enqueue adds n requests to the queue of the given key, which drains
+	// a request every interval, if the queue has room for them within
+	// capacity requests at now. The queue is tracked by the time when it
+	// will be empty. It returns that time after the operation and whether
+	// the requests have been added.
+	Enqueue(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (time.Time, bool, error)
+
+	// GetEmptyAt returns the time when the queue of the given key will be
+	// empty. A zero value time is returned if the key does not exist.
+	GetEmptyAt(ctx context.Context, key string) (time.Time, error)
+
+	// Dequeue removes n requests from the queue of the given key by moving
+	// the time when it will be empty back by n intervals, but not before
+	// now. It returns that time after the operation.
+	Dequeue(ctx context.Context, key string, now time.Time, interval time.Duration, n int) (time.Time, error)
+
+	// DeleteQueue removes the queue of the given key.
+	DeleteQueue(ctx context.Context, key string) error
+}
+
+// TimestampRepository interfaces the interaction with the underlying
+// store where a single timestamp is persisted per key
+type TimestampRepository interface {
+	// GetTimestamp returns the timestamp of the given key. A zero value
+	// timestamp is returned if the key does not exist.
+	GetTimestamp(ctx context.Context, key string) (time.Time, error)
+
+	// CompareAndSetTimestamp atomically sets the timestamp of the given
+	// key to value only if it is currently equal to expected. A key that
+	// does not exist is equal to a zero value timestamp. It returns
+	// whether the timestamp has been set.
+	CompareAndSetTimestamp(ctx context.Context, key string, expected, value time.Time) (bool, error)
+
+	// DeleteTimestamp removes the timestamp of the given key.
+	DeleteTimestamp(ctx context.Context, key string) error
 }
@@ -0,0 +1,203 @@
+// Package repositorytest provides the tests that every implementation of
+// repository.Repository has to pass, so that the tests of a backend only
+// need to cover what is specific to it, e.g. its expiry.
+package repositorytest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+// T0 is the start of the windows used by the tests. A backend that expires
+// the windows by its own clock should set it to T0.
+var T0 = time.Unix(1600000000, 0)
+
+// Run runs the tests of the request counts against the repositories
+// returned by newRepo, which has to return an empty repository every time
+// it is called. Example:
+//
+//   func TestRepository(t *testing.T) {
+//       repositorytest.Run(t, func(t *testing.T) repository.Repository {
+//           return newTestRepository(t)
+//       })
+//   }
+func Run(t *testing.T, newRepo func(t *testing.T) repository.Repository) {
+	t.Run("IncrementByKey", func(t *testing.T) {
+		testIncrementByKey(t, newRepo(t))
+	})
+	t.Run("IncrementByKey_RaceCondition", func(t *testing.T) {
+		testIncrementByKeyRaceCondition(t, newRepo(t))
+	})
+	t.Run("DecrementByKey", func(t *testing.T) {
+		testDecrementByKey(t, newRepo(t))
+	})
+	t.Run("DeleteByKey", func(t *testing.T) {
+		testDeleteByKey(t, newRepo(t))
+	})
+	t.Run("FixedWindowRateLimiter_SharedAcrossInstances", func(t *testing.T) {
+		testSharedAcrossInstances(t, newRepo(t))
+	})
+}
+
+func testIncrementByKey(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	t1 := T0.Add(time.Minute)
+
+	count, err := repo.IncrementByKey(ctx, "key1", T0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = repo.IncrementByKeyN(ctx, "key1", T0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// every window and key has its own count
+	count, err = repo.IncrementByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = repo.IncrementByKey(ctx, "key2", T0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// revert an increment
+	count, err = repo.IncrementByKeyN(ctx, "key1", T0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	count, err = repo.GetByKey(ctx, "key1", T0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	count, err = repo.GetByKey(ctx, "key3", T0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func testIncrementByKeyRaceCondition(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.IncrementByKey(ctx, "key1", T0)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	count, err := repo.GetByKey(ctx, "key1", T0)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, count)
+}
+
+func testDecrementByKey(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	t1 := T0.Add(time.Minute)
+
+	_, err := repo.IncrementByKeyN(ctx, "key1", T0, 3)
+	require.NoError(t, err)
+	_, err = repo.IncrementByKeyN(ctx, "key1", t1, 1)
+	require.NoError(t, err)
+
+	count, err := repo.DecrementByKey(ctx, "key1", T0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// should not go below zero
+	count, err = repo.DecrementByKey(ctx, "key1", t1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// should not create a window or key that does not exist
+	count, err = repo.DecrementByKey(ctx, "key1", t1.Add(time.Minute), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = repo.DecrementByKey(ctx, "key2", T0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	for _, key := range []string{"key1", "key2"} {
+		count, err = repo.IncrementByKey(ctx, key, t1.Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+	}
+}
+
+func testDeleteByKey(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	windows := []time.Time{T0.Add(-time.Hour), T0, T0.Add(time.Second), T0.Add(time.Minute)}
+
+	for _, w := range windows {
+		_, err := repo.IncrementByKeyN(ctx, "key1", w, 3)
+		require.NoError(t, err)
+	}
+	_, err := repo.IncrementByKey(ctx, "key1:a", T0)
+	require.NoError(t, err)
+	_, err = repo.IncrementByKey(ctx, "key2", T0)
+	require.NoError(t, err)
+
+	// only the windows of key1 are deleted, whatever their duration
+	assert.NoError(t, repo.DeleteByKey(ctx, "key1"))
+	for _, w := range windows {
+		count, err := repo.GetByKey(ctx, "key1", w)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	}
+	for _, key := range []string{"key1:a", "key2"} {
+		count, err := repo.GetByKey(ctx, key, T0)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count, key)
+	}
+
+	// the key starts over, and a key that does not exist is no error
+	count, err := repo.IncrementByKey(ctx, "key1", T0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.NoError(t, repo.DeleteByKey(ctx, "key3"))
+}
+
+func testSharedAcrossInstances(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	mockClock.Set(T0)
+
+	// e.g. the limiters of 2 instances of a service
+	r1 := ratelimiter.NewFixedWindowRateLimiter(3, time.Minute, repo, mockClock)
+	r2 := ratelimiter.NewFixedWindowRateLimiter(3, time.Minute, repo, mockClock)
+
+	res, err := r1.AllowN(ctx, "user_123", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Allowed)
+
+	res, err = r2.Allow(ctx, "user_123")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 3, Remaining: 0}, res)
+
+	res, err = r1.Allow(ctx, "user_123")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+
+	// a reset by one of them is seen by the other
+	require.NoError(t, r2.Reset(ctx, "user_123"))
+	res, err = r2.Allow(ctx, "user_123")
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 1, Limit: 3, Remaining: 2}, res)
+
+	// the next window has its own limit
+	mockClock.Add(time.Minute)
+	res, err = r1.AllowN(ctx, "user_123", 3)
+	require.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 3, Limit: 3, Remaining: 0}, res)
+}
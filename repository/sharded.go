@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+)
+
+// DefaultShards is the number of shards of ShardedInMemRepository when
+// it is not given
+const DefaultShards = 32
+
+// ShardedInMemRepository is an in-mem repository that spreads the keys
+// over a number of InMemRepository shards by the hash of the key. Every
+// shard has its own lock, so requests of keys in different shards do not
+// contend with each other. It has the same behavior as InMemRepository
+// otherwise, including losing the rate limits on server restarts.
+type ShardedInMemRepository struct {
+	shards []*InMemRepository
+}
+
+// NewShardedInMemRepository returns a new instance of in-mem repository
+// with the given number of shards, or DefaultShards if it is not
+// positive. More shards reduce contention at the cost of a few more
+// maps, which are only allocated with the shards.
+func NewShardedInMemRepository(shards int) *ShardedInMemRepository {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	r := &ShardedInMemRepository{shards: make([]*InMemRepository, shards)}
+	for i := range r.shards {
+		r.shards[i] = NewInMemRepository()
+	}
+	return r
+}
+
+// shard returns the shard of the given key
+func (r *ShardedInMemRepository) shard(key string) *InMemRepository {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return r.shards[h.Sum32()%uint32(len(r.shards))]
+}
+
+// IncrementByKey increases the request count for the given key and
+// current window by 1. Refer to InMemRepository.IncrementByKey for how
+// the time windows are kept track of.
+func (r *ShardedInMemRepository) IncrementByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	return r.shard(key).IncrementByKey(ctx, key, window)
+}
+
+// IncrementByKeyN increases the request count for the given key and
+// current window by n
+func (r *ShardedInMemRepository) IncrementByKeyN(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	return r.shard(key).IncrementByKeyN(ctx, key, window, n)
+}
+
+// GetByKey returns the request count for the given key and window if
+// it is still tracked
+func (r *ShardedInMemRepository) GetByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	return r.shard(key).GetByKey(ctx, key, window)
+}
+
+// DecrementByKey decreases the request count for the given key and
+// window by n if it is still tracked
+func (r *ShardedInMemRepository) DecrementByKey(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	return r.shard(key).DecrementByKey(ctx, key, window, n)
+}
+
+// DeleteByKey removes every tracked window of the given key
+func (r *ShardedInMemRepository) DeleteByKey(ctx context.Context, key string) error {
+	return r.shard(key).DeleteByKey(ctx, key)
+}
+
+// IncrementWithPrevious increases the request count for the given key
+// and current window by n, and returns the count of prevWindow
+func (r *ShardedInMemRepository) IncrementWithPrevious(ctx context.Context, key string, window, prevWindow time.Time, n int) (int, int, error) {
+	return r.shard(key).IncrementWithPrevious(ctx, key, window, prevWindow, n)
+}
+
+// GetWithPrevious returns the request counts for the given key of window
+// and prevWindow
+func (r *ShardedInMemRepository) GetWithPrevious(ctx context.Context, key string, window, prevWindow time.Time) (int, int, error) {
+	return r.shard(key).GetWithPrevious(ctx, key, window, prevWindow)
+}
+
+// AppendLog appends now n times to the log of the given key if there is
+// room for them
+func (r *ShardedInMemRepository) AppendLog(ctx context.Context, key string, now, since time.Time, limit, n int) ([]time.Time, bool, error) {
+	return r.shard(key).AppendLog(ctx, key, now, since, limit, n)
+}
+
+// GetLog returns the timestamps of the given key that are after since
+func (r *ShardedInMemRepository) GetLog(ctx context.Context, key string, since time.Time) ([]time.Time, error) {
+	return r.shard(key).GetLog(ctx, key, since)
+}
+
+// PopLog removes up to n of the newest timestamps of the given key
+func (r *ShardedInMemRepository) PopLog(ctx context.Context, key string, n int) error {
+	return r.shard(key).PopLog(ctx, key, n)
+}
+
+// DeleteLog removes the log of the given key
+func (r *ShardedInMemRepository) DeleteLog(ctx context.Context, key string) error {
+	return r.shard(key).DeleteLog(ctx, key)
+}
+
+// TakeTokens refills the bucket of the given key and takes n tokens out
+// of it if there are enough
+func (r *ShardedInMemRepository) TakeTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (TokenBucket, bool, error) {
+	return r.shard(key).TakeTokens(ctx, key, now, interval, capacity, n)
+}
+
+// ReserveTokens refills the bucket of the given key and takes n tokens
+// out of it, going into debt if there are not enough
+func (r *ShardedInMemRepository) ReserveTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (TokenBucket, error) {
+	return r.shard(key).ReserveTokens(ctx, key, now, interval, capacity, n)
+}
+
+// PutTokens refills the bucket of the given key and puts n tokens back
+// into it, up to capacity
+func (r *ShardedInMemRepository) PutTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (TokenBucket, error) {
+	return r.shard(key).PutTokens(ctx, key, now, interval, capacity, n)
+}
+
+// GetTokens returns the refilled bucket of the given key without storing
+// it
+func (r *ShardedInMemRepository) GetTokens(ctx context.Context, key string, now time.Time, interval time.Duration, capacity int) (TokenBucket, error) {
+	return r.shard(key).GetTokens(ctx, key, now, interval, capacity)
+}
+
+// DeleteTokens removes the bucket of the given key
+func (r *ShardedInMemRepository) DeleteTokens(ctx context.Context, key string) error {
+	return r.shard(key).DeleteTokens(ctx, key)
+}
+
+// Enqueue adds n requests to the queue of the given key if they fit
+func (r *ShardedInMemRepository) Enqueue(ctx context.Context, key string, now time.Time, interval time.Duration, capacity, n int) (time.Time, bool, error) {
+	return r.shard(key).Enqueue(ctx, key, now, interval, capacity, n)
+}
+
+// GetEmptyAt returns the time when the queue of the given key will be
+// empty
+func (r *ShardedInMemRepository) GetEmptyAt(ctx context.Context, key string) (time.Time, error) {
+	return r.shard(key).GetEmptyAt(ctx, key)
+}
+
+// Dequeue moves the time when the queue of the given key will be empty
+// back by n intervals, but not before now
+func (r *ShardedInMemRepository) Dequeue(ctx context.Context, key string, now time.Time, interval time.Duration, n int) (time.Time, error) {
+	return r.shard(key).Dequeue(ctx, key, now, interval, n)
+}
+
+// DeleteQueue removes the queue of the given key
+func (r *ShardedInMemRepository) DeleteQueue(ctx context.Context, key string) error {
+	return r.shard(key).DeleteQueue(ctx, key)
+}
+
+// GetTimestamp returns the timestamp of the given key
+func (r *ShardedInMemRepository) GetTimestamp(ctx context.Context, key string) (time.Time, error) {
+	return r.shard(key).GetTimestamp(ctx, key)
+}
+
+// CompareAndSetTimestamp sets the timestamp of the given key to value
+// if it has not been changed from expected
+func (r *ShardedInMemRepository) CompareAndSetTimestamp(ctx context.Context, key string, expected, value time.Time) (bool, error) {
+	return r.shard(key).CompareAndSetTimestamp(ctx, key, expected, value)
+}
+
+// DeleteTimestamp removes the timestamp of the given key
+func (r *ShardedInMemRepository) DeleteTimestamp(ctx context.Context, key string) error {
+	return r.shard(key).DeleteTimestamp(ctx, key)
+}
@@ -0,0 +1,124 @@
+package repository_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+func TestShardedInMemRepository(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	t1 := t0.Add(time.Minute)
+	sharded := repository.NewShardedInMemRepository(4)
+
+	// every key keeps its own count across the shards
+	for i := 0; i < 20; i++ {
+		count, err := sharded.IncrementByKeyN(ctx, "key"+strconv.Itoa(i), t0, i+1)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, count)
+	}
+	for i := 0; i < 20; i++ {
+		count, err := sharded.GetByKey(ctx, "key"+strconv.Itoa(i), t0)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, count)
+	}
+
+	count, prevCount, err := sharded.IncrementWithPrevious(ctx, "key1", t1, t0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 2, prevCount)
+
+	assert.NoError(t, sharded.DeleteByKey(ctx, "key1"))
+	count, err = sharded.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = sharded.GetByKey(ctx, "key2", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	_, appended, err := sharded.AppendLog(ctx, "key1", t0, t0.Add(-time.Minute), 1, 1)
+	assert.NoError(t, err)
+	assert.True(t, appended)
+
+	b, taken, err := sharded.TakeTokens(ctx, "key1", t0, time.Second, 5, 2)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	assert.Equal(t, 3, b.Tokens)
+
+	emptyAt, ok, err := sharded.Enqueue(ctx, "key1", t0, time.Second, 5, 2)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(2*time.Second), emptyAt)
+
+	ok, err = sharded.CompareAndSetTimestamp(ctx, "key1", time.Time{}, t1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	tat, err := sharded.GetTimestamp(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, t1, tat)
+}
+
+func TestShardedInMemRepository_RaceCondition(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	sharded := repository.NewShardedInMemRepository(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := sharded.IncrementByKey(ctx, "key"+strconv.Itoa(i%10), t0)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		count, err := sharded.GetByKey(ctx, "key"+strconv.Itoa(i), t0)
+		assert.NoError(t, err)
+		assert.Equal(t, 10, count)
+	}
+}
+
+// benchmarkIncrementByKey increments keys out of the given number of
+// distinct keys from every parallel goroutine
+func benchmarkIncrementByKey(b *testing.B, repo repository.Repository, keys int) {
+	ctx := context.Background()
+	window := time.Unix(1600000000, 0)
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "key" + strconv.Itoa(i)
+	}
+
+	var seq uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(atomic.AddUint64(&seq, 1))
+		for pb.Next() {
+			if _, err := repo.IncrementByKey(ctx, names[i%keys], window); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkIncrementByKey(b *testing.B) {
+	for _, keys := range []int{4, 10000} {
+		b.Run("InMem/keys="+strconv.Itoa(keys), func(b *testing.B) {
+			benchmarkIncrementByKey(b, repository.NewInMemRepository(), keys)
+		})
+		b.Run("Sharded/keys="+strconv.Itoa(keys), func(b *testing.B) {
+			benchmarkIncrementByKey(b, repository.NewShardedInMemRepository(0), keys)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"container/list"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotVersion is the version of the snapshot format that is written
+// by InMemRepository.Snapshot
+const SnapshotVersion = 1
+
+// snapshot is the format of InMemRepository.Snapshot. It is a JSON
+// object with every time formatted as RFC 3339 with nanoseconds:
+//
+//   {
+//     "version": 1,
+//     "windows": {"<key>": [{"time": "<window start>", "count": 3}]},
+//     "logs": {"<key>": ["<request time>"]},
+//     "buckets": {"<key>": {"tokens": 2, "last_refill": "<time>"}},
+//     "queues": {"<key>": "<time when the queue is empty>"},
+//     "timestamps": {"<key>": "<theoretical arrival time>"}
+//   }
+//
+// Fields may be added to a version, but a field is never removed or
+// changed without bumping the version.
+type snapshot struct {
+	Version    int                         `json:"version"`
+	Windows    map[string][]snapshotWindow `json:"windows"`
+	Logs       map[string][]time.Time      `json:"logs"`
+	Buckets    map[string]snapshotBucket   `json:"buckets"`
+	Queues     map[string]time.Time        `json:"queues"`
+	Timestamps map[string]time.Time        `json:"timestamps"`
+}
+
+type snapshotWindow struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+}
+
+type snapshotBucket struct {
+	Tokens     int       `json:"tokens"`
+	LastRefill time.Time `json:"last_refill"`
+}
+
+// Snapshot writes the state of every key to w as versioned JSON, e.g. to
+// checkpoint the rate limits on graceful shutdown and load them back with
+// Restore on boot. The state is copied while holding the lock, and then
+// written without it so that a slow writer does not block requests.
+func (r *InMemRepository) Snapshot(w io.Writer) error {
+	s := snapshot{
+		Version:    SnapshotVersion,
+		Windows:    map[string][]snapshotWindow{},
+		Logs:       map[string][]time.Time{},
+		Buckets:    map[string]snapshotBucket{},
+		Queues:     map[string]time.Time{},
+		Timestamps: map[string]time.Time{},
+	}
+
+	r.mu.Lock()
+	for key, windows := range r.store {
+		for _, w := range windows {
+			s.Windows[key] = append(s.Windows[key], snapshotWindow{Time: w.time, Count: w.count})
+		}
+	}
+	for key, log := range r.logs {
+		if len(log) > 0 {
+			s.Logs[key] = append([]time.Time{}, log...)
+		}
+	}
+	for key, b := range r.buckets {
+		s.Buckets[key] = snapshotBucket{Tokens: b.Tokens, LastRefill: b.LastRefill}
+	}
+	for key, t := range r.queues {
+		s.Queues[key] = t
+	}
+	for key, t := range r.timestamps {
+		s.Timestamps[key] = t
+	}
+	r.mu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+	return nil
+}
+
+// Restore replaces the state of every key with the snapshot read from rd,
+// which has been written by Snapshot. The state that has expired at now
+// is discarded:
+//   - windows that started, and log timestamps that were made, maxAge or
+//     longer before now. Set maxAge to the longest duration of the limiters
+//     using the repository, or twice as long for the sliding window
+//     counter which also reads the previous window.
+//   - queues that are empty and timestamps that are not after now, which
+//     are the same as their zero value.
+//
+// Token buckets are always kept, as they may not be full yet.
+func (r *InMemRepository) Restore(rd io.Reader, now time.Time, maxAge time.Duration) error {
+	var s snapshot
+	if err := json.NewDecoder(rd).Decode(&s); err != nil {
+		return errors.Wrap(err, "failed to read snapshot")
+	}
+	if s.Version != SnapshotVersion {
+		return errors.Errorf("unsupported snapshot version %d", s.Version)
+	}
+
+	since := now.Add(-maxAge)
+	store := map[string][]*windowObj{}
+	for key, windows := range s.Windows {
+		var objs []*windowObj
+		for _, w := range windows {
+			if w.Time.After(since) {
+				objs = append(objs, &windowObj{time: w.Time, count: w.Count})
+			}
+		}
+		if len(objs) == 0 {
+			continue
+		}
+		sort.Slice(objs, func(i, j int) bool {
+			return objs[i].time.Before(objs[j].time)
+		})
+		if len(objs) > maxWindows {
+			objs = objs[len(objs)-maxWindows:]
+		}
+		store[key] = objs
+	}
+
+	logs := map[string][]time.Time{}
+	for key, log := range s.Logs {
+		var kept []time.Time
+		for _, t := range log {
+			if t.After(since) {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) > 0 {
+			sort.Slice(kept, func(i, j int) bool {
+				return kept[i].Before(kept[j])
+			})
+			logs[key] = kept
+		}
+	}
+
+	buckets := map[string]TokenBucket{}
+	for key, b := range s.Buckets {
+		buckets[key] = TokenBucket{Tokens: b.Tokens, LastRefill: b.LastRefill}
+	}
+
+	queues := map[string]time.Time{}
+	for key, t := range s.Queues {
+		if t.After(now) {
+			queues[key] = t
+		}
+	}
+
+	timestamps := map[string]time.Time{}
+	for key, t := range s.Timestamps {
+		if t.After(now) {
+			timestamps[key] = t
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store = store
+	r.logs = logs
+	r.buckets = buckets
+	r.queues = queues
+	r.timestamps = timestamps
+
+	// the keys that do not fit into maxKeys are evicted in no particular
+	// order, as the snapshot does not keep the order of the updates
+	if r.lru != nil {
+		r.lru.Init()
+		r.lruKeys = map[string]*list.Element{}
+		for key := range store {
+			r.touch(key)
+		}
+		for key := range logs {
+			r.touch(key)
+		}
+		for key := range buckets {
+			r.touch(key)
+		}
+		for key := range queues {
+			r.touch(key)
+		}
+		for key := range timestamps {
+			r.touch(key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+func TestSnapshotAndRestore(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	t1 := t0.Add(time.Minute)
+	t2 := t1.Add(time.Minute)
+
+	inMem := repository.NewInMemRepository()
+	_, err := inMem.IncrementByKeyN(ctx, "key1", t0, 2)
+	require.NoError(t, err)
+	_, err = inMem.IncrementByKeyN(ctx, "key1", t1, 3)
+	require.NoError(t, err)
+	_, err = inMem.IncrementByKeyN(ctx, "key2", t0, 1)
+	require.NoError(t, err)
+	_, _, err = inMem.AppendLog(ctx, "key1", t0, t0.Add(-time.Minute), 5, 1)
+	require.NoError(t, err)
+	_, _, err = inMem.AppendLog(ctx, "key1", t1, t0, 5, 2)
+	require.NoError(t, err)
+	_, err = inMem.ReserveTokens(ctx, "key1", t1, time.Second, 5, 7)
+	require.NoError(t, err)
+	_, _, err = inMem.Enqueue(ctx, "key1", t1, time.Minute, 5, 2)
+	require.NoError(t, err)
+	_, _, err = inMem.Enqueue(ctx, "key2", t0, time.Second, 5, 1)
+	require.NoError(t, err)
+	_, err = inMem.CompareAndSetTimestamp(ctx, "key1", time.Time{}, t2.Add(time.Second))
+	require.NoError(t, err)
+	_, err = inMem.CompareAndSetTimestamp(ctx, "key2", time.Time{}, t1)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, inMem.Snapshot(&buf))
+
+	// restore at t2 with the windows of up to 1 minute, which discards
+	// everything that has expired before the restart
+	restored := repository.NewInMemRepository()
+	_, err = restored.IncrementByKey(ctx, "key3", t2)
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(&buf, t2, time.Minute+time.Second))
+
+	count, err := restored.GetByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	count, err = restored.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = restored.GetByKey(ctx, "key2", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// the state before restoring is replaced
+	count, err = restored.GetByKey(ctx, "key3", t2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	log, err := restored.GetLog(ctx, "key1", time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, log, 2)
+	assert.True(t, log[0].Equal(t1))
+
+	b, err := restored.GetTokens(ctx, "key1", t1, time.Second, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, -2, b.Tokens)
+	assert.True(t, b.LastRefill.Equal(t1))
+
+	emptyAt, err := restored.GetEmptyAt(ctx, "key1")
+	assert.NoError(t, err)
+	assert.True(t, emptyAt.Equal(t1.Add(2*time.Minute)))
+	emptyAt, err = restored.GetEmptyAt(ctx, "key2")
+	assert.NoError(t, err)
+	assert.True(t, emptyAt.IsZero())
+
+	tat, err := restored.GetTimestamp(ctx, "key2")
+	assert.NoError(t, err)
+	assert.True(t, tat.IsZero())
+	tat, err = restored.GetTimestamp(ctx, "key1")
+	assert.NoError(t, err)
+	assert.True(t, tat.Equal(t2.Add(time.Second)))
+
+	// a restored timestamp can be compared and set with the value it was
+	// read as
+	ok, err := restored.CompareAndSetTimestamp(ctx, "key1", tat, t2.Add(2*time.Second))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestSnapshot_Format(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+
+	inMem := repository.NewInMemRepository()
+	_, err := inMem.IncrementByKeyN(ctx, "key1", t0, 2)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, inMem.Snapshot(&buf))
+	assert.JSONEq(t, `{
+		"version": 1,
+		"windows": {"key1": [{"time": "2020-09-13T12:26:40Z", "count": 2}]},
+		"logs": {},
+		"buckets": {},
+		"queues": {},
+		"timestamps": {}
+	}`, buf.String())
+}
+
+func TestRestore_Error(t *testing.T) {
+	inMem := repository.NewInMemRepository()
+	now := time.Unix(1600000000, 0)
+
+	err := inMem.Restore(strings.NewReader(`{"version": 2}`), now, time.Minute)
+	assert.EqualError(t, err, "unsupported snapshot version 2")
+
+	err = inMem.Restore(strings.NewReader(`{`), now, time.Minute)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read snapshot")
+}
+
+func TestRestore_MaxKeys(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+
+	inMem := repository.NewInMemRepository()
+	_, err := inMem.IncrementByKey(ctx, "key1", t0)
+	require.NoError(t, err)
+	_, err = inMem.IncrementByKey(ctx, "key2", t0)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, inMem.Snapshot(&buf))
+
+	// only one of the keys fits
+	restored := repository.NewInMemRepositoryWithOpts(repository.InMemOpts{MaxKeys: 1})
+	require.NoError(t, restored.Restore(&buf, t0, time.Minute))
+	count1, err := restored.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	count2, err := restored.GetByKey(ctx, "key2", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count1+count2)
+}
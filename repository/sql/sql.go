@@ -0,0 +1,205 @@
+// Package sql provides a repository implementation backed by a relational
+// database through database/sql, so that the request counts are persisted
+// and can be shared by multiple instances of a service. The queries are
+// written for PostgreSQL and SQLite 3.35 or later.
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+)
+
+// DefaultTable is the default of Opts.Table
+const DefaultTable = "ratelimit_counters"
+
+// DefaultExpiration is the default of Opts.Expiration
+const DefaultExpiration = time.Hour
+
+// Opts stores the configuration options of Repository
+type Opts struct {
+	// Table is the name of the table that stores the request counts. It is
+	// written into the queries as is, so it must not come from user input.
+	// Defaults to DefaultTable.
+	Table string
+
+	// Expiration is how long the row of a window is kept after the window
+	// starts, before Purge deletes it. Defaults to DefaultExpiration.
+	Expiration time.Duration
+
+	// OnPurgeError is called with the error when a purge of RunPurge
+	// fails, e.g. to log it. RunPurge keeps purging either way.
+	OnPurgeError func(err error)
+}
+
+// Repository is repository implementation with a SQL database. The
+// request count of every key and window is a row of the table, which is
+// created or incremented with a single upsert, so that the count stays
+// correct when multiple instances increment it at the same time.
+//
+// The table has to be created before use, e.g. with Migrate.
+type Repository struct {
+	db           *dbsql.DB
+	expiration   time.Duration
+	queries      queries
+	onPurgeError func(err error)
+}
+
+type queries struct {
+	increment string
+	get       string
+	decrement string
+	delete    string
+	purge     string
+	migrate   []string
+}
+
+// NewRepository returns a new instance of SQL repository. It takes a
+// database that has been opened by the caller. Example:
+//
+//   db, err := sql.Open("postgres", "postgres://localhost/app")
+//   repo := sql.NewRepository(db, sql.Opts{Expiration: time.Minute})
+//   err = repo.Migrate(ctx)
+func NewRepository(db *dbsql.DB, opts Opts) *Repository {
+	table := opts.Table
+	if table == "" {
+		table = DefaultTable
+	}
+	expiration := opts.Expiration
+	if expiration <= 0 {
+		expiration = DefaultExpiration
+	}
+	return &Repository{
+		db:           db,
+		expiration:   expiration,
+		queries:      newQueries(table),
+		onPurgeError: opts.OnPurgeError,
+	}
+}
+
+// newQueries returns the queries of the given table. Windows and
+// expirations are stored as Unix nanoseconds, so that they compare the
+// same way in every database. SQLite numbers the parameters in the order
+// they first appear, so they have to appear in the order of their number.
+func newQueries(table string) queries {
+	return queries{
+		increment: `INSERT INTO ` + table + ` (limit_key, window_start, count, expire_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT (limit_key, window_start) DO UPDATE SET count = ` + table + `.count + excluded.count
+RETURNING count`,
+		get: `SELECT count FROM ` + table + ` WHERE limit_key = $1 AND window_start = $2`,
+		decrement: `UPDATE ` + table + ` SET count = CASE WHEN count > $1 THEN count - $1 ELSE 0 END
+WHERE limit_key = $2 AND window_start = $3
+RETURNING count`,
+		delete: `DELETE FROM ` + table + ` WHERE limit_key = $1`,
+		purge:  `DELETE FROM ` + table + ` WHERE expire_at <= $1`,
+		migrate: []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+	limit_key VARCHAR(255) NOT NULL,
+	window_start BIGINT NOT NULL,
+	count BIGINT NOT NULL,
+	expire_at BIGINT NOT NULL,
+	PRIMARY KEY (limit_key, window_start)
+)`,
+			`CREATE INDEX IF NOT EXISTS ` + table + `_expire_at_idx ON ` + table + ` (expire_at)`,
+		},
+	}
+}
+
+// Migrate creates the table of the repository and its index if they do
+// not exist yet
+func (r *Repository) Migrate(ctx context.Context) error {
+	for _, q := range r.queries.migrate {
+		if _, err := r.db.ExecContext(ctx, q); err != nil {
+			return errors.Wrap(err, "failed to migrate sql table")
+		}
+	}
+	return nil
+}
+
+// IncrementByKey increases the request count for the given key and
+// window by 1
+func (r *Repository) IncrementByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	return r.IncrementByKeyN(ctx, key, window, 1)
+}
+
+// IncrementByKeyN increases the request count for the given key and
+// window by n
+func (r *Repository) IncrementByKeyN(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, r.queries.increment,
+		key, window.UnixNano(), n, window.Add(r.expiration).UnixNano()).Scan(&count)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to increment sql count")
+	}
+	return count, nil
+}
+
+// GetByKey returns the request count for the given key and window
+func (r *Repository) GetByKey(ctx context.Context, key string, window time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, r.queries.get, key, window.UnixNano()).Scan(&count)
+	if err == dbsql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get sql count")
+	}
+	return count, nil
+}
+
+// DecrementByKey decreases the request count for the given key and
+// window by n, but not below zero. It does nothing if the window does not
+// exist.
+func (r *Repository) DecrementByKey(ctx context.Context, key string, window time.Time, n int) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, r.queries.decrement, n, key, window.UnixNano()).Scan(&count)
+	if err == dbsql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to decrement sql count")
+	}
+	return count, nil
+}
+
+// DeleteByKey removes the request counts of every window of the given key
+func (r *Repository) DeleteByKey(ctx context.Context, key string) error {
+	if _, err := r.db.ExecContext(ctx, r.queries.delete, key); err != nil {
+		return errors.Wrap(err, "failed to delete sql counts")
+	}
+	return nil
+}
+
+// Purge removes the request counts that have expired at now and returns
+// how many of them have been removed
+func (r *Repository) Purge(ctx context.Context, now time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, r.queries.purge, now.UnixNano())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge sql counts")
+	}
+	return res.RowsAffected()
+}
+
+// RunPurge calls Purge every interval until ctx is done, and returns
+// ctx.Err(). A failed purge is reported to Opts.OnPurgeError and tried
+// again on the next interval. It is meant to be run in its own goroutine.
+// Example:
+//
+//   go repo.RunPurge(ctx, time.Minute, clock.New())
+func (r *Repository) RunPurge(ctx context.Context, interval time.Duration, clock clock.Clock) error {
+	ticker := clock.Ticker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			if _, err := r.Purge(ctx, now); err != nil && ctx.Err() == nil && r.onPurgeError != nil {
+				r.onPurgeError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package sql_test
+
+import (
+	"context"
+	dbsql "database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/repositorytest"
+	"github.com/yonasstephen/ratelimiter/repository/sql"
+)
+
+// newTestRepository returns a migrated repository backed by a SQLite
+// database in a temporary file
+func newTestRepository(t *testing.T, opts sql.Opts) (*sql.Repository, *dbsql.DB) {
+	dsn := filepath.Join(t.TempDir(), "ratelimit.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+	db, err := dbsql.Open("sqlite3", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	repo := sql.NewRepository(db, opts)
+	require.NoError(t, repo.Migrate(context.Background()))
+	return repo, db
+}
+
+func countRows(t *testing.T, db *dbsql.DB, table string) int {
+	var n int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table).Scan(&n))
+	return n
+}
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		repo, _ := newTestRepository(t, sql.Opts{})
+		return repo
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, db := newTestRepository(t, sql.Opts{Table: "counters"})
+
+	_, err := repo.IncrementByKeyN(ctx, "key1", t0, 3)
+	require.NoError(t, err)
+	_, err = repo.IncrementByKey(ctx, "key2", t0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, countRows(t, db, "counters"))
+
+	// should be a no-op to migrate again
+	assert.NoError(t, repo.Migrate(ctx))
+	count, err := repo.GetByKey(ctx, "key1", t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	t1 := t0.Add(time.Minute)
+	repo, db := newTestRepository(t, sql.Opts{Expiration: time.Minute})
+
+	_, err := repo.IncrementByKey(ctx, "key1", t0)
+	require.NoError(t, err)
+	_, err = repo.IncrementByKey(ctx, "key1", t1)
+	require.NoError(t, err)
+
+	n, err := repo.Purge(ctx, t1.Add(-time.Nanosecond))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	// the window of t0 expires at t1
+	n, err = repo.Purge(ctx, t1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, 1, countRows(t, db, sql.DefaultTable))
+	count, err := repo.GetByKey(ctx, "key1", t1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestRunPurge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	repo, db := newTestRepository(t, sql.Opts{Expiration: time.Minute})
+
+	_, err := repo.IncrementByKey(ctx, "key1", mockClock.Now())
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- repo.RunPurge(ctx, 30*time.Second, mockClock)
+	}()
+
+	// the clock is moved until the ticker has been created and the count
+	// has expired
+	assert.Eventually(t, func() bool {
+		mockClock.Add(30 * time.Second)
+		return countRows(t, db, sql.DefaultTable) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestRunPurge_Error(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mockClock := clock.NewMock()
+	mockClock.Set(time.Unix(1600000000, 0))
+	purgeErrs := make(chan error, 10)
+	repo, db := newTestRepository(t, sql.Opts{
+		Expiration:   time.Minute,
+		OnPurgeError: func(err error) { purgeErrs <- err },
+	})
+
+	// the purge fails while the table is missing
+	_, err := db.Exec("DROP TABLE " + sql.DefaultTable)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- repo.RunPurge(ctx, 30*time.Second, mockClock)
+	}()
+
+	var purgeErr error
+	assert.Eventually(t, func() bool {
+		mockClock.Add(30 * time.Second)
+		select {
+		case purgeErr = <-purgeErrs:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+	require.Error(t, purgeErr)
+	assert.Contains(t, purgeErr.Error(), "no such table")
+
+	// and keeps purging once the table is back
+	require.NoError(t, repo.Migrate(ctx))
+	_, err = repo.IncrementByKey(ctx, "key1", mockClock.Now())
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		mockClock.Add(30 * time.Second)
+		return countRows(t, db, sql.DefaultTable) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestRepository_DatabaseError(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Unix(1600000000, 0)
+	repo, db := newTestRepository(t, sql.Opts{})
+	db.Close()
+
+	_, err := repo.IncrementByKey(ctx, "key1", t0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to increment sql count")
+
+	_, err = repo.GetByKey(ctx, "key1", t0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get sql count")
+}
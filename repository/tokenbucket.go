@@ -0,0 +1,39 @@
+package repository
+
+import "time"
+
+// TokenBucket is the state of a token bucket
+type TokenBucket struct {
+	// Tokens is the number of tokens left in the bucket. It is negative
+	// when the bucket is in debt of reserved tokens.
+	Tokens int
+
+	// LastRefill is the time when the last token was added to the bucket.
+	// The next token will be added at LastRefill + interval.
+	LastRefill time.Time
+}
+
+// Refill returns the state of the bucket at now after adding a token for
+// every interval that has elapsed since the last refill, up to capacity.
+// It is meant to be shared by repository implementations so that they
+// refill buckets in the same way.
+func (b TokenBucket) Refill(now time.Time, interval time.Duration, capacity int) TokenBucket {
+	if b.Tokens >= capacity {
+		// a full bucket does not accumulate refill time
+		return TokenBucket{Tokens: capacity, LastRefill: now}
+	}
+
+	elapsed := now.Sub(b.LastRefill)
+	if elapsed < interval {
+		return b
+	}
+
+	n := int(elapsed / interval)
+	if b.Tokens+n >= capacity {
+		return TokenBucket{Tokens: capacity, LastRefill: now}
+	}
+	return TokenBucket{
+		Tokens:     b.Tokens + n,
+		LastRefill: b.LastRefill.Add(time.Duration(n) * interval),
+	}
+}
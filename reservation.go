@@ -0,0 +1,74 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// Reserver is implemented by rate limiters that can reserve capacity
+// ahead of time
+type Reserver interface {
+	// Reserve reserves n units of the given key and returns a Reservation
+	// that tells when the units may be used. The reservation should be
+	// cancelled if the units end up not being used.
+	Reserve(ctx context.Context, key string, n int) (*Reservation, error)
+}
+
+// Reservation holds units of a key that have been reserved by a Reserver.
+// It is safe for concurrent use.
+type Reservation struct {
+	clock     clock.Clock
+	ok        bool
+	timeToAct time.Time
+	limit     int
+
+	mu        sync.Mutex
+	cancelled bool
+	cancel    func(ctx context.Context) error
+}
+
+// OK returns whether the units have been reserved. If it is false, the
+// limiter could not find room for the units and there is nothing to use
+// or cancel.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// TimeToAct returns the time at which the reserved units may be used
+func (r *Reservation) TimeToAct() time.Time {
+	return r.timeToAct
+}
+
+// Delay returns the duration until the reserved units may be used. A zero
+// value means that they may be used right away.
+func (r *Reservation) Delay() time.Duration {
+	delay := r.timeToAct.Sub(r.clock.Now())
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Limit returns the limit that was used to make the reservation
+func (r *Reservation) Limit() int {
+	return r.limit
+}
+
+// Cancel gives the reserved units back to the limiter so that they can
+// be used by other requests. Calling Cancel more than once, or on a
+// reservation that is not OK, is a no-op.
+func (r *Reservation) Cancel(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.ok || r.cancelled {
+		return nil
+	}
+	if err := r.cancel(ctx); err != nil {
+		return err
+	}
+	r.cancelled = true
+	return nil
+}
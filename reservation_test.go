@@ -0,0 +1,168 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+func TestFixedWindowReserve(t *testing.T) {
+	mockClock := clock.NewMock()
+	mockClock.Add(2 * time.Second)
+	r := ratelimiter.NewFixedWindowRateLimiter(3, 5*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	// current window has room
+	res, err := r.Reserve(ctx, "test_key", 2)
+	require.NoError(t, err)
+	assert.True(t, res.OK())
+	assert.Equal(t, mockClock.Now(), res.TimeToAct())
+	assert.Equal(t, time.Duration(0), res.Delay())
+
+	// only 1 left in the current window, should reserve the next window
+	next, err := r.Reserve(ctx, "test_key", 2)
+	require.NoError(t, err)
+	assert.True(t, next.OK())
+	assert.Equal(t, time.Unix(5, 0), next.TimeToAct())
+	assert.Equal(t, 3*time.Second, next.Delay())
+
+	// the rest of the current window is still available
+	allowed, err := r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, allowed.Allowed)
+
+	// both windows are full
+	full, err := r.Reserve(ctx, "test_key", 2)
+	require.NoError(t, err)
+	assert.False(t, full.OK())
+	assert.NoError(t, full.Cancel(ctx))
+
+	// cancelling gives the next window back
+	require.NoError(t, next.Cancel(ctx))
+	require.NoError(t, next.Cancel(ctx))
+	mockClock.Add(3 * time.Second)
+	allowed, err = r.AllowN(ctx, "test_key", 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, allowed.Allowed)
+
+	// cancelling after the window has ended is a no-op
+	require.NoError(t, res.Cancel(ctx))
+	allowed, err = r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 0, allowed.Allowed)
+
+	res, err = r.Reserve(ctx, "test_key", 4)
+	assert.Nil(t, res)
+	assert.Equal(t, ratelimiter.ErrExceedsLimit, err)
+}
+
+func TestFixedWindowReserve_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewFixedWindowRateLimiter(3, 5*time.Second, mockRepo, mockClock)
+
+	mockRepo.EXPECT().IncrementByKeyN(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now()), gomock.Eq(1)).Return(0, errors.New("unexpected repo error"))
+	res, err := r.Reserve(context.Background(), "test_key", 1)
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to increment repository: unexpected repo error")
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewTokenBucketRateLimiter(1, time.Second, 3, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	// bucket has enough tokens
+	res, err := r.Reserve(ctx, "test_key", 2)
+	require.NoError(t, err)
+	assert.True(t, res.OK())
+	assert.Equal(t, time.Duration(0), res.Delay())
+	assert.Equal(t, 3, res.Limit())
+
+	// bucket goes into debt of 2 tokens
+	debt, err := r.Reserve(ctx, "test_key", 3)
+	require.NoError(t, err)
+	assert.True(t, debt.OK())
+	assert.Equal(t, time.Unix(2, 0), debt.TimeToAct())
+	assert.Equal(t, 2*time.Second, debt.Delay())
+
+	// later reservations queue up behind the debt
+	later, err := r.Reserve(ctx, "test_key", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, later.Delay())
+
+	allowed, err := r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 0, allowed.Allowed)
+	assert.Equal(t, 0, allowed.Remaining)
+	assert.Equal(t, 4*time.Second, allowed.RetryAfter)
+
+	// cancelling pays back the debt
+	require.NoError(t, debt.Cancel(ctx))
+	require.NoError(t, later.Cancel(ctx))
+	allowed, err = r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, allowed.Allowed)
+	assert.Equal(t, 0, allowed.Remaining)
+}
+
+func TestTokenBucketReserve_CancelAfterTimeToAct(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewTokenBucketRateLimiter(1, time.Second, 3, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.Reserve(ctx, "test_key", 3)
+	require.NoError(t, err)
+	debt, err := r.Reserve(ctx, "test_key", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, debt.Delay())
+
+	// the reserved tokens may have been used, so they are not put back
+	mockClock.Add(3 * time.Second)
+	require.NoError(t, debt.Cancel(ctx))
+	allowed, err := r.Allow(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, allowed.Allowed)
+	assert.Equal(t, 0, allowed.Remaining)
+}
+
+func TestTokenBucketReserve_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTokenBucketRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewTokenBucketRateLimiter(1, time.Second, 3, mockRepo, mockClock)
+	ctx := context.Background()
+
+	mockRepo.EXPECT().ReserveTokens(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now()), gomock.Eq(time.Second), gomock.Eq(3), gomock.Eq(1)).Return(repository.TokenBucket{}, errors.New("unexpected repo error"))
+	res, err := r.Reserve(ctx, "test_key", 1)
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to reserve tokens from repository: unexpected repo error")
+
+	// failed cancellation can be retried
+	mockRepo.EXPECT().ReserveTokens(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now()), gomock.Eq(time.Second), gomock.Eq(3), gomock.Eq(1)).Return(repository.TokenBucket{Tokens: 2, LastRefill: mockClock.Now()}, nil)
+	res, err = r.Reserve(ctx, "test_key", 1)
+	require.NoError(t, err)
+
+	gomock.InOrder(
+		mockRepo.EXPECT().PutTokens(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now()), gomock.Eq(time.Second), gomock.Eq(3), gomock.Eq(1)).Return(repository.TokenBucket{}, errors.New("unexpected repo error")),
+		mockRepo.EXPECT().PutTokens(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now()), gomock.Eq(time.Second), gomock.Eq(3), gomock.Eq(1)).Return(repository.TokenBucket{Tokens: 3, LastRefill: mockClock.Now()}, nil),
+	)
+	assert.EqualError(t, res.Cancel(ctx), "failed to put tokens back to repository: unexpected repo error")
+	assert.NoError(t, res.Cancel(ctx))
+	assert.NoError(t, res.Cancel(ctx))
+}
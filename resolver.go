@@ -0,0 +1,140 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// defaultLimitCacheSize is the number of keys whose limits are cached by
+// CachedLimitResolver
+const defaultLimitCacheSize = 10000
+
+// Limit is the rate limit of a key i.e. Limit requests per Duration
+type Limit struct {
+	Limit    int
+	Duration time.Duration
+}
+
+// LimitResolver resolves the rate limit of a key, e.g. from the plan of
+// the customer that the key belongs to
+type LimitResolver interface {
+	// Resolve returns the rate limit of the given key
+	Resolve(ctx context.Context, key string) (Limit, error)
+}
+
+// StaticLimitResolver resolves the rate limits of keys from a fixed map,
+// and the default limit for the keys that are not in it
+type StaticLimitResolver struct {
+	limits       map[string]Limit
+	defaultLimit Limit
+}
+
+// NewStaticLimitResolver returns a new instance of static limit resolver.
+// Example:
+//
+//   resolver := NewStaticLimitResolver(map[string]Limit{
+//       "premium_customer": {Limit: 1000, Duration: time.Minute},
+//   }, Limit{Limit: 10, Duration: time.Minute})
+func NewStaticLimitResolver(limits map[string]Limit, defaultLimit Limit) *StaticLimitResolver {
+	copied := make(map[string]Limit, len(limits))
+	for key, limit := range limits {
+		copied[key] = limit
+	}
+	return &StaticLimitResolver{
+		limits:       copied,
+		defaultLimit: defaultLimit,
+	}
+}
+
+// Resolve returns the limit of the given key, or the default limit if it
+// has none
+func (r *StaticLimitResolver) Resolve(ctx context.Context, key string) (Limit, error) {
+	if limit, ok := r.limits[key]; ok {
+		return limit, nil
+	}
+	return r.defaultLimit, nil
+}
+
+// CachedLimitResolver caches the limits of another resolver for a ttl, so
+// that a resolver that looks the limits up in e.g. a database is not
+// called on every request. It is safe for concurrent use as long as the
+// wrapped resolver is.
+//
+// Up to defaultLimitCacheSize keys are cached. When the cache is full,
+// expired keys are purged to make room and if there is still no room, the
+// limit is simply not cached.
+type CachedLimitResolver struct {
+	resolver LimitResolver
+	ttl      time.Duration
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]cachedLimit
+}
+
+type cachedLimit struct {
+	limit     Limit
+	expiresAt time.Time
+}
+
+// NewCachedLimitResolver returns a new instance of cached limit resolver
+// in front of the given resolver. A change of the limit of a key takes up
+// to ttl to be picked up, unless the key is invalidated.
+func NewCachedLimitResolver(resolver LimitResolver, ttl time.Duration, clock clock.Clock) *CachedLimitResolver {
+	return &CachedLimitResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		clock:    clock,
+		entries:  map[string]cachedLimit{},
+	}
+}
+
+// Resolve returns the cached limit of the given key, or resolves it with
+// the wrapped resolver if it is not cached or has expired. Errors are not
+// cached.
+func (r *CachedLimitResolver) Resolve(ctx context.Context, key string) (Limit, error) {
+	now := r.clock.Now()
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.limit, nil
+	}
+
+	limit, err := r.resolver.Resolve(ctx, key)
+	if err != nil {
+		return Limit{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[key]; !ok && len(r.entries) >= defaultLimitCacheSize {
+		r.purge(now)
+		if len(r.entries) >= defaultLimitCacheSize {
+			return limit, nil
+		}
+	}
+	r.entries[key] = cachedLimit{limit: limit, expiresAt: now.Add(r.ttl)}
+	return limit, nil
+}
+
+// Invalidate removes the cached limit of the given key, e.g. when the
+// customer has changed plans
+func (r *CachedLimitResolver) Invalidate(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, key)
+}
+
+// purge removes the expired limits. It must be called while holding the
+// lock.
+func (r *CachedLimitResolver) purge(now time.Time) {
+	for key, entry := range r.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(r.entries, key)
+		}
+	}
+}
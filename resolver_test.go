@@ -0,0 +1,85 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+)
+
+type countingResolver struct {
+	calls int
+	limit ratelimiter.Limit
+	err   error
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, key string) (ratelimiter.Limit, error) {
+	r.calls++
+	return r.limit, r.err
+}
+
+func TestStaticLimitResolver(t *testing.T) {
+	limits := map[string]ratelimiter.Limit{
+		"premium_key": {Limit: 100, Duration: time.Minute},
+	}
+	resolver := ratelimiter.NewStaticLimitResolver(limits, ratelimiter.Limit{Limit: 10, Duration: time.Minute})
+
+	// the map is copied
+	limits["free_key"] = ratelimiter.Limit{Limit: 1000, Duration: time.Minute}
+
+	limit, err := resolver.Resolve(context.Background(), "premium_key")
+	require.NoError(t, err)
+	assert.Equal(t, ratelimiter.Limit{Limit: 100, Duration: time.Minute}, limit)
+
+	limit, err = resolver.Resolve(context.Background(), "free_key")
+	require.NoError(t, err)
+	assert.Equal(t, ratelimiter.Limit{Limit: 10, Duration: time.Minute}, limit)
+}
+
+func TestCachedLimitResolver(t *testing.T) {
+	ctx := context.Background()
+	mockClock := clock.NewMock()
+	wrapped := &countingResolver{limit: ratelimiter.Limit{Limit: 10, Duration: time.Minute}}
+	resolver := ratelimiter.NewCachedLimitResolver(wrapped, time.Minute, mockClock)
+
+	for i := 0; i < 3; i++ {
+		limit, err := resolver.Resolve(ctx, "test_key")
+		require.NoError(t, err)
+		assert.Equal(t, wrapped.limit, limit)
+	}
+	assert.Equal(t, 1, wrapped.calls)
+
+	// the limit is resolved again once it has expired
+	wrapped.limit = ratelimiter.Limit{Limit: 100, Duration: time.Minute}
+	mockClock.Add(time.Minute)
+	limit, err := resolver.Resolve(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 100, limit.Limit)
+	assert.Equal(t, 2, wrapped.calls)
+
+	// or once it has been invalidated
+	resolver.Invalidate("test_key")
+	_, err = resolver.Resolve(ctx, "test_key")
+	require.NoError(t, err)
+	assert.Equal(t, 3, wrapped.calls)
+}
+
+func TestCachedLimitResolver_Error(t *testing.T) {
+	ctx := context.Background()
+	wrapped := &countingResolver{err: errors.New("plan not found")}
+	resolver := ratelimiter.NewCachedLimitResolver(wrapped, time.Minute, clock.NewMock())
+
+	_, err := resolver.Resolve(ctx, "test_key")
+	assert.EqualError(t, err, "plan not found")
+
+	// errors are not cached
+	_, err = resolver.Resolve(ctx, "test_key")
+	assert.Error(t, err)
+	assert.Equal(t, 2, wrapped.calls)
+}
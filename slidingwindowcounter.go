@@ -0,0 +1,235 @@
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+// SlidingWindowCounterRateLimiter is an implementation of RateLimiter
+// interface with a sliding window counter algorithm. It approximates the
+// request rate of the trailing duration by weighting the count of the
+// previous fixed window by how much of it still overlaps the trailing
+// duration, and adding it to the count of the current fixed window.
+//
+// Unlike SlidingWindowLogRateLimiter, it only needs 2 counters per key.
+type SlidingWindowCounterRateLimiter struct {
+	clock     clock.Clock
+	duration  time.Duration
+	limit     int
+	repo      repository.SlidingWindowRepository
+	keyPrefix string
+}
+
+// NewSlidingWindowCounterRateLimiter returns an instance of sliding window
+// counter rate limiter. It takes limit & duration. The rate is defined as
+// limit/duration. Example:
+//
+//   limit := 100
+//   duration := time.Minute
+//   // this allows approximately 100 requests within any 1 minute period
+//   rateLimiter := NewSlidingWindowCounterRateLimiter(limit, duration, repo, clock)
+func NewSlidingWindowCounterRateLimiter(limit int, duration time.Duration, repo repository.SlidingWindowRepository, clock clock.Clock) *SlidingWindowCounterRateLimiter {
+	return &SlidingWindowCounterRateLimiter{
+		clock:    clock,
+		duration: duration,
+		limit:    limit,
+		repo:     repo,
+	}
+}
+
+// NewSlidingWindowCounterRateLimiterWithOptions returns an instance of
+// sliding window counter rate limiter like
+// NewSlidingWindowCounterRateLimiter, but with the clock and repository
+// given as options. It returns a *ConfigError if limit or duration is not
+// positive.
+func NewSlidingWindowCounterRateLimiterWithOptions(limit int, duration time.Duration, opts ...Option) (*SlidingWindowCounterRateLimiter, error) {
+	if err := validatePositive("limit", limit); err != nil {
+		return nil, err
+	}
+	if err := validateDuration(duration); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	repo, ok := o.repo.(repository.SlidingWindowRepository)
+	if !ok {
+		return nil, o.repoError("repository.SlidingWindowRepository")
+	}
+	r := NewSlidingWindowCounterRateLimiter(limit, duration, repo, o.clock)
+	r.keyPrefix = o.keyPrefix
+	return r, nil
+}
+
+// Allow increments the request rate of the given key for the current
+// time window and returns the result based on the weighted count of
+// the current and previous time windows
+func (r *SlidingWindowCounterRateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+// AllowN increments the request rate of the given key for the current
+// time window by n and returns the result based on the weighted count
+// of the current and previous time windows
+func (r *SlidingWindowCounterRateLimiter) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	key = r.keyPrefix + key
+	if err := validateN(n, r.limit); err != nil {
+		return nil, err
+	}
+
+	now := r.clock.Now()
+	window := now.Truncate(r.duration)
+	prevWindow := window.Add(-r.duration)
+
+	count, prevCount, allowed, err := r.incrementWithinLimit(ctx, key, now, window, prevWindow, n)
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := now.Sub(window)
+	estimate := float64(prevCount)*float64(r.duration-elapsed)/float64(r.duration) + float64(count)
+
+	if !allowed {
+		remaining := 0
+		if estimate < float64(r.limit) {
+			remaining = int(math.Floor(float64(r.limit) - estimate))
+		}
+		return &Result{
+			Allowed:    0,
+			Limit:      r.limit,
+			Remaining:  remaining,
+			RetryAfter: r.retryAfter(now, window, count, prevCount, n),
+			ResetAfter: r.resetAfter(now, window, count, prevCount),
+		}, nil
+	}
+
+	resetAfter := r.resetAfter(now, window, count, prevCount)
+	return &Result{
+		Allowed:    n,
+		Limit:      r.limit,
+		Remaining:  int(math.Floor(float64(r.limit) - estimate)),
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+// Status returns the state of the rate limit of the given key based on
+// the weighted count of the current and previous time windows without
+// incrementing them
+func (r *SlidingWindowCounterRateLimiter) Status(ctx context.Context, key string) (*Result, error) {
+	key = r.keyPrefix + key
+	now := r.clock.Now()
+	window := now.Truncate(r.duration)
+	prevWindow := window.Add(-r.duration)
+
+	count, prevCount, err := r.repo.GetWithPrevious(ctx, key, window, prevWindow)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get repository count")
+	}
+
+	elapsed := now.Sub(window)
+	estimate := float64(prevCount)*float64(r.duration-elapsed)/float64(r.duration) + float64(count)
+	res := &Result{
+		Allowed:    0,
+		Limit:      r.limit,
+		ResetAfter: r.resetAfter(now, window, count, prevCount),
+	}
+	if estimate+1 > float64(r.limit) {
+		res.RetryAfter = r.retryAfter(now, window, count, prevCount, 1)
+	} else {
+		res.Remaining = int(math.Floor(float64(r.limit) - estimate))
+	}
+	return res, nil
+}
+
+// Reset removes the request counts of the given key
+func (r *SlidingWindowCounterRateLimiter) Reset(ctx context.Context, key string) error {
+	key = r.keyPrefix + key
+	return errors.Wrap(r.repo.DeleteByKey(ctx, key), "failed to delete repository key")
+}
+
+// Refund decrements the request rate of the given key for the current
+// time window by n, but not below zero. The previous time window is left
+// as is, so a refund after the window of the request has ended only
+// takes back what has been used in the new window so far.
+func (r *SlidingWindowCounterRateLimiter) Refund(ctx context.Context, key string, n int) error {
+	key = r.keyPrefix + key
+	if n < 1 {
+		return ErrInvalidN
+	}
+
+	window := r.clock.Now().Truncate(r.duration)
+	if _, err := r.repo.DecrementByKey(ctx, key, window, n); err != nil {
+		return errors.Wrap(err, "failed to decrement repository")
+	}
+	return nil
+}
+
+// incrementWithinLimit increments the count of window by n if the
+// weighted count stays within the limit, and returns the counts of window
+// and prevWindow after the operation. A repository that can not check the
+// limit by itself is incremented first, and reverted if the limit is
+// exceeded.
+func (r *SlidingWindowCounterRateLimiter) incrementWithinLimit(ctx context.Context, key string, now, window, prevWindow time.Time, n int) (int, int, bool, error) {
+	if repo, ok := r.repo.(repository.SlidingWindowLimitRepository); ok {
+		count, prevCount, ok, err := repo.IncrementWithinLimit(ctx, key, now, window, prevWindow, r.limit, n)
+		if err != nil {
+			return 0, 0, false, errors.Wrap(err, "failed to increment repository")
+		}
+		return count, prevCount, ok, nil
+	}
+
+	count, prevCount, err := r.repo.IncrementWithPrevious(ctx, key, window, prevWindow, n)
+	if err != nil {
+		return 0, 0, false, errors.Wrap(err, "failed to increment repository")
+	}
+
+	elapsed := now.Sub(window)
+	estimate := float64(prevCount)*float64(r.duration-elapsed)/float64(r.duration) + float64(count)
+	if estimate <= float64(r.limit) {
+		return count, prevCount, true, nil
+	}
+
+	// revert the increment so that smaller requests can still use the rest
+	// of the limit
+	if _, err := r.repo.DecrementByKey(ctx, key, window, n); err != nil {
+		return 0, 0, false, errors.Wrap(err, "failed to revert repository increment")
+	}
+	return count - n, prevCount, false, nil
+}
+
+// retryAfter returns the duration until the weighted count has decayed
+// enough for n more units to fit, assuming no other requests come in
+func (r *SlidingWindowCounterRateLimiter) retryAfter(now, window time.Time, count, prevCount, n int) time.Duration {
+	d := float64(r.duration)
+
+	// the previous window keeps decaying within the current window, so
+	// the request fits once prevCount*(d-elapsed)/d <= limit-count-n
+	if count+n <= r.limit && prevCount > 0 {
+		elapsed := d - float64(r.limit-count-n)*d/float64(prevCount)
+		return window.Add(time.Duration(math.Ceil(elapsed))).Sub(now)
+	}
+
+	// otherwise the current window has to become the previous window and
+	// decay until count*(d-elapsed)/d <= limit-n
+	nextWindow := window.Add(r.duration)
+	if count == 0 || count <= r.limit-n {
+		return nextWindow.Sub(now)
+	}
+	elapsed := d - float64(r.limit-n)*d/float64(count)
+	return nextWindow.Add(time.Duration(math.Ceil(elapsed))).Sub(now)
+}
+
+// resetAfter returns the duration until both windows have fully decayed
+func (r *SlidingWindowCounterRateLimiter) resetAfter(now, window time.Time, count, prevCount int) time.Duration {
+	if count > 0 {
+		return window.Add(2 * r.duration).Sub(now)
+	}
+	if prevCount > 0 {
+		return window.Add(r.duration).Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,285 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+func TestSlidingWindowCounterAllow(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewSlidingWindowCounterRateLimiter(4, 10*time.Second, repository.NewInMemRepository(), mockClock)
+
+	// t=5s, fill up the first window
+	mockClock.Add(5 * time.Second)
+	for i := 0; i < 4; i++ {
+		res, err := r.Allow(context.Background(), "test_key")
+		assert.NoError(t, err)
+		assert.Equal(t, &ratelimiter.Result{
+			Allowed:    1,
+			Limit:      4,
+			Remaining:  3 - i,
+			ResetAfter: 15 * time.Second,
+		}, res)
+	}
+
+	testCases := []struct {
+		name            string
+		requestInterval time.Duration
+		expectedResult  *ratelimiter.Result
+	}{
+		{
+			// 4*0.8 + 1 = 4.2, fits once the previous window has decayed to 4*0.75
+			name:            "t=12s previous window still weighs too much",
+			requestInterval: 7 * time.Second,
+			expectedResult: &ratelimiter.Result{
+				Allowed:    0,
+				Limit:      4,
+				Remaining:  0,
+				RetryAfter: 500 * time.Millisecond,
+				ResetAfter: 8 * time.Second,
+			},
+		},
+		{
+			// 4*0.75 + 1 = 4, the rejected request has not been counted
+			name:            "t=12.5s fits as computed by the previous retry after",
+			requestInterval: 500 * time.Millisecond,
+			expectedResult: &ratelimiter.Result{
+				Allowed:    1,
+				Limit:      4,
+				Remaining:  0,
+				ResetAfter: 17500 * time.Millisecond,
+			},
+		},
+		{
+			// 4*0.5 + 2 = 4
+			name:            "t=15s previous window has decayed",
+			requestInterval: 2500 * time.Millisecond,
+			expectedResult: &ratelimiter.Result{
+				Allowed:    1,
+				Limit:      4,
+				Remaining:  0,
+				ResetAfter: 15 * time.Second,
+			},
+		},
+		{
+			// 4*0.4 + 3 = 4.6, fits once the previous window has decayed to 4*0.25
+			name:            "t=16s previous window still weighs too much",
+			requestInterval: time.Second,
+			expectedResult: &ratelimiter.Result{
+				Allowed:    0,
+				Limit:      4,
+				Remaining:  0,
+				RetryAfter: 1500 * time.Millisecond,
+				ResetAfter: 14 * time.Second,
+			},
+		},
+		{
+			// 4*0.25 + 3 = 4
+			name:            "t=17.5s fits as computed by the previous retry after",
+			requestInterval: 1500 * time.Millisecond,
+			expectedResult: &ratelimiter.Result{
+				Allowed:    1,
+				Limit:      4,
+				Remaining:  0,
+				ResetAfter: 12500 * time.Millisecond,
+			},
+		},
+		{
+			// 4*0.1 + 4 = 4.4, fits only once the previous window has fully decayed
+			name:            "t=19s retry on the next window",
+			requestInterval: 1500 * time.Millisecond,
+			expectedResult: &ratelimiter.Result{
+				Allowed:    0,
+				Limit:      4,
+				Remaining:  0,
+				RetryAfter: time.Second,
+				ResetAfter: 11 * time.Second,
+			},
+		},
+		{
+			// 3*1 + 1 = 4
+			name:            "t=20s fits on the next window",
+			requestInterval: time.Second,
+			expectedResult: &ratelimiter.Result{
+				Allowed:    1,
+				Limit:      4,
+				Remaining:  0,
+				ResetAfter: 20 * time.Second,
+			},
+		},
+		{
+			// nothing is left from the window of t=20s after t=40s
+			name:            "t=41s both windows have decayed",
+			requestInterval: 21 * time.Second,
+			expectedResult: &ratelimiter.Result{
+				Allowed:    1,
+				Limit:      4,
+				Remaining:  3,
+				ResetAfter: 19 * time.Second,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		mockClock.Add(tc.requestInterval)
+		res, err := r.Allow(context.Background(), "test_key")
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expectedResult, res, tc.name)
+	}
+}
+
+func TestSlidingWindowCounterAllow_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockSlidingWindowRepository(ctrl)
+	mockClock := clock.NewMock()
+	mockClock.Add(90 * time.Second)
+	r := ratelimiter.NewSlidingWindowCounterRateLimiter(5, time.Minute, mockRepo, mockClock)
+
+	expectedWindow, _ := time.Parse(time.RFC3339, "1970-01-01T00:01:00Z")
+	expectedPrevWindow, _ := time.Parse(time.RFC3339, "1970-01-01T00:00:00Z")
+	mockRepo.
+		EXPECT().
+		IncrementWithPrevious(gomock.Any(), gomock.Eq("test_key"), matchesTime(expectedWindow), matchesTime(expectedPrevWindow), gomock.Eq(1)).
+		Return(0, 0, errors.New("unexpected repo error"))
+
+	res, err := r.Allow(context.Background(), "test_key")
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to increment repository: unexpected repo error")
+}
+
+func TestSlidingWindowCounterAllowN(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewSlidingWindowCounterRateLimiter(10, 10*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.AllowN(ctx, "test_key", 8)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 8, Limit: 10, Remaining: 2, ResetAfter: 20 * time.Second}, res)
+
+	// 8 has to decay to 8*0.25 in the next window to fit 8 more
+	res, err = r.AllowN(ctx, "test_key", 8)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{
+		Allowed:    0,
+		Limit:      10,
+		Remaining:  2,
+		RetryAfter: 17500 * time.Millisecond,
+		ResetAfter: 20 * time.Second,
+	}, res)
+
+	// the rejected request has been reverted, so the rest of the limit
+	// can still be used
+	res, err = r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 2, Limit: 10, Remaining: 0, ResetAfter: 20 * time.Second}, res)
+}
+
+func TestSlidingWindowCounterAllowN_LimitRepository(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockSlidingWindowLimitRepository(ctrl)
+	mockClock := clock.NewMock()
+	mockClock.Add(90 * time.Second)
+	r := ratelimiter.NewSlidingWindowCounterRateLimiter(10, time.Minute, mockRepo, mockClock)
+	ctx := context.Background()
+
+	now := mockClock.Now()
+	expectedWindow, _ := time.Parse(time.RFC3339, "1970-01-01T00:01:00Z")
+	expectedPrevWindow, _ := time.Parse(time.RFC3339, "1970-01-01T00:00:00Z")
+	mockRepo.
+		EXPECT().
+		IncrementWithinLimit(gomock.Any(), gomock.Eq("test_key"), matchesTime(now), matchesTime(expectedWindow), matchesTime(expectedPrevWindow), gomock.Eq(10), gomock.Eq(2)).
+		Return(4, 8, true, nil)
+	res, err := r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 2, Limit: 10, Remaining: 2, ResetAfter: 90 * time.Second}, res)
+
+	// the repository has checked the limit, so there is nothing to revert
+	mockRepo.
+		EXPECT().
+		IncrementWithinLimit(gomock.Any(), gomock.Eq("test_key"), matchesTime(now), matchesTime(expectedWindow), matchesTime(expectedPrevWindow), gomock.Eq(10), gomock.Eq(3)).
+		Return(4, 8, false, nil)
+	res, err = r.AllowN(ctx, "test_key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{
+		Allowed:    0,
+		Limit:      10,
+		Remaining:  2,
+		RetryAfter: 7500 * time.Millisecond,
+		ResetAfter: 90 * time.Second,
+	}, res)
+
+	mockRepo.
+		EXPECT().
+		IncrementWithinLimit(gomock.Any(), gomock.Eq("test_key"), matchesTime(now), matchesTime(expectedWindow), matchesTime(expectedPrevWindow), gomock.Eq(10), gomock.Eq(1)).
+		Return(0, 0, false, errors.New("unexpected repo error"))
+	res, err = r.Allow(ctx, "test_key")
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to increment repository: unexpected repo error")
+}
+
+func TestSlidingWindowCounterStatus(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewSlidingWindowCounterRateLimiter(4, 10*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.AllowN(ctx, "test_key", 4)
+	assert.NoError(t, err)
+
+	// the previous window still weighs in fully, status should not count
+	// as a request
+	mockClock.Add(10 * time.Second)
+	for i := 0; i < 2; i++ {
+		res, err := r.Status(ctx, "test_key")
+		assert.NoError(t, err)
+		assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 4, Remaining: 0, RetryAfter: 2500 * time.Millisecond, ResetAfter: 10 * time.Second}, res)
+	}
+
+	// the previous window has decayed to 3
+	mockClock.Add(2500 * time.Millisecond)
+	res, err := r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 4, Remaining: 1, ResetAfter: 7500 * time.Millisecond}, res)
+}
+
+func TestSlidingWindowCounterResetAndRefund(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewSlidingWindowCounterRateLimiter(4, 10*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.AllowN(ctx, "test_key", 4)
+	assert.NoError(t, err)
+
+	// the refund after the window has ended leaves the previous window
+	// as is
+	mockClock.Add(10 * time.Second)
+	assert.NoError(t, r.Refund(ctx, "test_key", 2))
+	res, err := r.Allow(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.Allowed)
+
+	// the rejected request is counted in the new window and can be
+	// refunded
+	assert.NoError(t, r.Refund(ctx, "test_key", 2))
+	res, err = r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 4, Remaining: 0, RetryAfter: 2500 * time.Millisecond, ResetAfter: 10 * time.Second}, res)
+
+	assert.NoError(t, r.Reset(ctx, "test_key"))
+	res, err = r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 4, Remaining: 4}, res)
+}
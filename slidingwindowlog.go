@@ -0,0 +1,149 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+// SlidingWindowLogRateLimiter is an implementation of RateLimiter interface
+// with a sliding window log algorithm. It keeps the timestamp of every
+// allowed request, so the limit holds over any trailing duration instead
+// of over fixed windows.
+type SlidingWindowLogRateLimiter struct {
+	clock     clock.Clock
+	duration  time.Duration
+	limit     int
+	repo      repository.LogRepository
+	keyPrefix string
+}
+
+// NewSlidingWindowLogRateLimiter returns an instance of sliding window log
+// rate limiter. It takes limit & duration. The rate is defined as
+// limit/duration over the trailing duration of every request. Example:
+//
+//   limit := 5
+//   duration := time.Minute
+//   // this allows at most 5 requests within any 1 minute period
+//   rateLimiter := NewSlidingWindowLogRateLimiter(limit, duration, repo, clock)
+func NewSlidingWindowLogRateLimiter(limit int, duration time.Duration, repo repository.LogRepository, clock clock.Clock) *SlidingWindowLogRateLimiter {
+	return &SlidingWindowLogRateLimiter{
+		clock:    clock,
+		duration: duration,
+		limit:    limit,
+		repo:     repo,
+	}
+}
+
+// NewSlidingWindowLogRateLimiterWithOptions returns an instance of sliding
+// window log rate limiter like NewSlidingWindowLogRateLimiter, but with the
+// clock and repository given as options. It returns a *ConfigError if
+// limit or duration is not positive.
+func NewSlidingWindowLogRateLimiterWithOptions(limit int, duration time.Duration, opts ...Option) (*SlidingWindowLogRateLimiter, error) {
+	if err := validatePositive("limit", limit); err != nil {
+		return nil, err
+	}
+	if err := validateDuration(duration); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	repo, ok := o.repo.(repository.LogRepository)
+	if !ok {
+		return nil, o.repoError("repository.LogRepository")
+	}
+	r := NewSlidingWindowLogRateLimiter(limit, duration, repo, o.clock)
+	r.keyPrefix = o.keyPrefix
+	return r, nil
+}
+
+// Allow records the request of the given key in the log if it is within
+// the limit of the trailing duration and returns the result
+func (r *SlidingWindowLogRateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+// AllowN records n requests of the given key in the log if they are
+// within the limit of the trailing duration and returns the result
+func (r *SlidingWindowLogRateLimiter) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	key = r.keyPrefix + key
+	if err := validateN(n, r.limit); err != nil {
+		return nil, err
+	}
+
+	now := r.clock.Now()
+	log, appended, err := r.repo.AppendLog(ctx, key, now, now.Add(-r.duration), r.limit, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to append repository log")
+	}
+
+	var resetAfter time.Duration
+	if len(log) > 0 {
+		resetAfter = log[len(log)-1].Add(r.duration).Sub(now)
+	}
+
+	if !appended {
+		// the request is allowed once enough of the oldest timestamps have
+		// moved out of the trailing duration to make room for it
+		expiring := len(log) + n - r.limit
+		retryAfter := log[expiring-1].Add(r.duration).Sub(now)
+		return &Result{
+			Allowed:    0,
+			Limit:      r.limit,
+			Remaining:  r.limit - len(log),
+			RetryAfter: retryAfter,
+			ResetAfter: resetAfter,
+		}, nil
+	}
+
+	return &Result{
+		Allowed:    n,
+		Limit:      r.limit,
+		Remaining:  r.limit - len(log),
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+// Status returns the state of the rate limit of the given key for the
+// trailing duration without recording a request in the log
+func (r *SlidingWindowLogRateLimiter) Status(ctx context.Context, key string) (*Result, error) {
+	key = r.keyPrefix + key
+	now := r.clock.Now()
+	log, err := r.repo.GetLog(ctx, key, now.Add(-r.duration))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get repository log")
+	}
+
+	res := &Result{
+		Allowed:   0,
+		Limit:     r.limit,
+		Remaining: r.limit - len(log),
+	}
+	if len(log) > 0 {
+		res.ResetAfter = log[len(log)-1].Add(r.duration).Sub(now)
+	}
+	if res.Remaining <= 0 {
+		// a request fits once the oldest timestamp beyond limit-1 expires
+		res.Remaining = 0
+		res.RetryAfter = log[len(log)-r.limit].Add(r.duration).Sub(now)
+	}
+	return res, nil
+}
+
+// Reset removes the log of the given key
+func (r *SlidingWindowLogRateLimiter) Reset(ctx context.Context, key string) error {
+	key = r.keyPrefix + key
+	return errors.Wrap(r.repo.DeleteLog(ctx, key), "failed to delete repository log")
+}
+
+// Refund removes the n newest timestamps of the given key from the log
+func (r *SlidingWindowLogRateLimiter) Refund(ctx context.Context, key string, n int) error {
+	key = r.keyPrefix + key
+	if n < 1 {
+		return ErrInvalidN
+	}
+	return errors.Wrap(r.repo.PopLog(ctx, key, n), "failed to pop repository log")
+}
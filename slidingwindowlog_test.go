@@ -0,0 +1,221 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+func TestSlidingWindowLogAllow(t *testing.T) {
+	testCases := []struct {
+		name             string
+		limit            int
+		duration         time.Duration
+		requestIntervals []time.Duration
+		expectedResults  []*ratelimiter.Result
+	}{
+		{
+			name:             "requests within limit",
+			limit:            3,
+			duration:         time.Duration(5 * time.Second),
+			requestIntervals: []time.Duration{0, time.Second, time.Second},
+			expectedResults: []*ratelimiter.Result{
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  2,
+					ResetAfter: time.Duration(5 * time.Second),
+				},
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  1,
+					ResetAfter: time.Duration(5 * time.Second),
+				},
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  0,
+					ResetAfter: time.Duration(5 * time.Second),
+				},
+			},
+		},
+		{
+			name:     "requests exceeds limit across fixed window boundary",
+			limit:    2,
+			duration: time.Duration(4 * time.Second),
+			// t=3s, t=3.5s, t=4s, t=6.9s, t=7s, t=7.4s
+			requestIntervals: []time.Duration{
+				3 * time.Second,
+				500 * time.Millisecond,
+				500 * time.Millisecond,
+				2900 * time.Millisecond,
+				100 * time.Millisecond,
+				400 * time.Millisecond,
+			},
+			expectedResults: []*ratelimiter.Result{
+				{
+					Allowed:    1,
+					Limit:      2,
+					Remaining:  1,
+					ResetAfter: time.Duration(4 * time.Second),
+				},
+				{
+					Allowed:    1,
+					Limit:      2,
+					Remaining:  0,
+					ResetAfter: time.Duration(4 * time.Second),
+				},
+				// a fixed window would have allowed this one
+				{
+					Allowed:    0,
+					Limit:      2,
+					Remaining:  0,
+					RetryAfter: time.Duration(3 * time.Second),
+					ResetAfter: time.Duration(3500 * time.Millisecond),
+				},
+				{
+					Allowed:    0,
+					Limit:      2,
+					Remaining:  0,
+					RetryAfter: time.Duration(100 * time.Millisecond),
+					ResetAfter: time.Duration(600 * time.Millisecond),
+				},
+				// the request at t=3s has expired
+				{
+					Allowed:    1,
+					Limit:      2,
+					Remaining:  0,
+					ResetAfter: time.Duration(4 * time.Second),
+				},
+				{
+					Allowed:    0,
+					Limit:      2,
+					Remaining:  0,
+					RetryAfter: time.Duration(100 * time.Millisecond),
+					ResetAfter: time.Duration(3600 * time.Millisecond),
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClock := clock.NewMock()
+			r := ratelimiter.NewSlidingWindowLogRateLimiter(tc.limit, tc.duration, repository.NewInMemRepository(), mockClock)
+
+			for i := 0; i < len(tc.expectedResults); i++ {
+				// move forward the time by specified interval
+				mockClock.Add(tc.requestIntervals[i])
+
+				res, err := r.Allow(context.Background(), "test_key")
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedResults[i], res, "request %d", i)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowLogAllow_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockLogRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewSlidingWindowLogRateLimiter(5, time.Minute, mockRepo, mockClock)
+
+	mockRepo.
+		EXPECT().
+		AppendLog(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now()), matchesTime(mockClock.Now().Add(-time.Minute)), gomock.Eq(5), gomock.Eq(1)).
+		Return(nil, false, errors.New("unexpected repo error"))
+
+	res, err := r.Allow(context.Background(), "test_key")
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to append repository log: unexpected repo error")
+}
+
+func TestSlidingWindowLogAllowN(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewSlidingWindowLogRateLimiter(5, 10*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.AllowN(ctx, "test_key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 3, Limit: 5, Remaining: 2, ResetAfter: 10 * time.Second}, res)
+
+	// 1 of the first 3 has to expire to make room
+	mockClock.Add(2 * time.Second)
+	res, err = r.AllowN(ctx, "test_key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{
+		Allowed:    0,
+		Limit:      5,
+		Remaining:  2,
+		RetryAfter: 8 * time.Second,
+		ResetAfter: 8 * time.Second,
+	}, res)
+
+	res, err = r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 2, Limit: 5, Remaining: 0, ResetAfter: 10 * time.Second}, res)
+}
+
+func TestSlidingWindowLogStatus(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewSlidingWindowLogRateLimiter(2, 10*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.Allow(ctx, "test_key")
+	assert.NoError(t, err)
+	mockClock.Add(4 * time.Second)
+	_, err = r.Allow(ctx, "test_key")
+	assert.NoError(t, err)
+
+	// status should not append to the log
+	mockClock.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		res, err := r.Status(ctx, "test_key")
+		assert.NoError(t, err)
+		assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 0, RetryAfter: 5 * time.Second, ResetAfter: 9 * time.Second}, res)
+	}
+
+	// the first request has moved out of the trailing duration
+	mockClock.Add(6 * time.Second)
+	res, err := r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 1, ResetAfter: 3 * time.Second}, res)
+}
+
+func TestSlidingWindowLogResetAndRefund(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewSlidingWindowLogRateLimiter(2, 10*time.Second, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.Allow(ctx, "test_key")
+	assert.NoError(t, err)
+	mockClock.Add(4 * time.Second)
+	_, err = r.Allow(ctx, "test_key")
+	assert.NoError(t, err)
+
+	// the newest request is removed from the log
+	assert.NoError(t, r.Refund(ctx, "test_key", 1))
+	res, err := r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 1, ResetAfter: 6 * time.Second}, res)
+
+	assert.NoError(t, r.Reset(ctx, "test_key"))
+	res, err = r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+
+	assert.Equal(t, ratelimiter.ErrInvalidN, r.Refund(ctx, "test_key", 0))
+}
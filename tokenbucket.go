@@ -0,0 +1,205 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+// TokenBucketRateLimiter is an implementation of RateLimiter interface
+// with a token bucket algorithm. Each key has a bucket that holds up to
+// burst tokens and is refilled at a constant rate. A request is allowed
+// if it can take a token out of the bucket, so clients can burst up to
+// the capacity of the bucket after being idle.
+type TokenBucketRateLimiter struct {
+	clock     clock.Clock
+	interval  time.Duration
+	burst     int
+	repo      repository.TokenBucketRepository
+	keyPrefix string
+}
+
+// NewTokenBucketRateLimiter returns an instance of token bucket rate limiter.
+// It takes rate & duration for the refill rate, which is defined as
+// rate/duration, and burst for the capacity of the bucket. Example:
+//
+//   rate := 10
+//   duration := time.Second
+//   burst := 50
+//   // this gives us a sustained rate of 10 requests per second with
+//   // bursts of up to 50 requests
+//   rateLimiter := NewTokenBucketRateLimiter(rate, duration, burst, repo, clock)
+//
+// It panics with a *ConfigError if rate or duration is not positive, or
+// if the rate is too high for the duration.
+func NewTokenBucketRateLimiter(rate int, duration time.Duration, burst int, repo repository.TokenBucketRepository, clock clock.Clock) *TokenBucketRateLimiter {
+	if err := validateRate(rate, duration); err != nil {
+		panic(err)
+	}
+	return &TokenBucketRateLimiter{
+		clock:    clock,
+		interval: duration / time.Duration(rate),
+		burst:    burst,
+		repo:     repo,
+	}
+}
+
+// NewTokenBucketRateLimiterWithOptions returns an instance of token bucket
+// rate limiter like NewTokenBucketRateLimiter, but with the clock and
+// repository given as options. It returns a *ConfigError if rate, duration,
+// or burst is not positive, or if the rate is too high for the duration.
+func NewTokenBucketRateLimiterWithOptions(rate int, duration time.Duration, burst int, opts ...Option) (*TokenBucketRateLimiter, error) {
+	if err := validateRate(rate, duration); err != nil {
+		return nil, err
+	}
+	if err := validatePositive("burst", burst); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	repo, ok := o.repo.(repository.TokenBucketRepository)
+	if !ok {
+		return nil, o.repoError("repository.TokenBucketRepository")
+	}
+	r := NewTokenBucketRateLimiter(rate, duration, burst, repo, o.clock)
+	r.keyPrefix = o.keyPrefix
+	return r, nil
+}
+
+// Allow takes a token out of the bucket of the given key and returns
+// the result
+func (r *TokenBucketRateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+// AllowN takes n tokens out of the bucket of the given key and returns
+// the result
+func (r *TokenBucketRateLimiter) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	key = r.keyPrefix + key
+	if err := validateN(n, r.burst); err != nil {
+		return nil, err
+	}
+
+	now := r.clock.Now()
+	bucket, taken, err := r.repo.TakeTokens(ctx, key, now, r.interval, r.burst, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to take token from repository")
+	}
+
+	// the bucket is full again once the missing tokens have been refilled
+	var resetAfter time.Duration
+	if bucket.Tokens < r.burst {
+		missing := time.Duration(r.burst - bucket.Tokens)
+		resetAfter = bucket.LastRefill.Add(missing * r.interval).Sub(now)
+	}
+
+	// the bucket may be in debt of reserved tokens
+	remaining := bucket.Tokens
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	if !taken {
+		missing := time.Duration(n - bucket.Tokens)
+		return &Result{
+			Allowed:    0,
+			Limit:      r.burst,
+			Remaining:  remaining,
+			RetryAfter: bucket.LastRefill.Add(missing * r.interval).Sub(now),
+			ResetAfter: resetAfter,
+		}, nil
+	}
+
+	return &Result{
+		Allowed:    n,
+		Limit:      r.burst,
+		Remaining:  remaining,
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+// Status returns the state of the bucket of the given key without taking
+// any token out of it
+func (r *TokenBucketRateLimiter) Status(ctx context.Context, key string) (*Result, error) {
+	key = r.keyPrefix + key
+	now := r.clock.Now()
+	bucket, err := r.repo.GetTokens(ctx, key, now, r.interval, r.burst)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tokens from repository")
+	}
+
+	res := &Result{
+		Allowed:   0,
+		Limit:     r.burst,
+		Remaining: bucket.Tokens,
+	}
+	if bucket.Tokens < r.burst {
+		missing := time.Duration(r.burst - bucket.Tokens)
+		res.ResetAfter = bucket.LastRefill.Add(missing * r.interval).Sub(now)
+	}
+	if bucket.Tokens < 1 {
+		// the bucket may be in debt of reserved tokens
+		missing := time.Duration(1 - bucket.Tokens)
+		res.Remaining = 0
+		res.RetryAfter = bucket.LastRefill.Add(missing * r.interval).Sub(now)
+	}
+	return res, nil
+}
+
+// Reset removes the bucket of the given key, so that it starts full again
+func (r *TokenBucketRateLimiter) Reset(ctx context.Context, key string) error {
+	key = r.keyPrefix + key
+	return errors.Wrap(r.repo.DeleteTokens(ctx, key), "failed to delete token bucket from repository")
+}
+
+// Refund puts n tokens back into the bucket of the given key, up to its
+// capacity
+func (r *TokenBucketRateLimiter) Refund(ctx context.Context, key string, n int) error {
+	key = r.keyPrefix + key
+	if n < 1 {
+		return ErrInvalidN
+	}
+
+	_, err := r.repo.PutTokens(ctx, key, r.clock.Now(), r.interval, r.burst, n)
+	return errors.Wrap(err, "failed to put tokens back to repository")
+}
+
+// Reserve takes n tokens out of the bucket of the given key even if there
+// are not enough of them, in which case the bucket goes into debt and the
+// reservation may be used once the missing tokens have been refilled.
+// Cancelling the reservation puts the tokens back into the bucket unless
+// the time to act has passed, as the tokens may have been used by then.
+func (r *TokenBucketRateLimiter) Reserve(ctx context.Context, key string, n int) (*Reservation, error) {
+	key = r.keyPrefix + key
+	if err := validateN(n, r.burst); err != nil {
+		return nil, err
+	}
+
+	now := r.clock.Now()
+	bucket, err := r.repo.ReserveTokens(ctx, key, now, r.interval, r.burst, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reserve tokens from repository")
+	}
+
+	timeToAct := now
+	if bucket.Tokens < 0 {
+		missing := time.Duration(-bucket.Tokens)
+		timeToAct = bucket.LastRefill.Add(missing * r.interval)
+	}
+	return &Reservation{
+		clock:     r.clock,
+		ok:        true,
+		timeToAct: timeToAct,
+		limit:     r.burst,
+		cancel: func(ctx context.Context) error {
+			if r.clock.Now().After(timeToAct) {
+				return nil
+			}
+			_, err := r.repo.PutTokens(ctx, key, r.clock.Now(), r.interval, r.burst, n)
+			return errors.Wrap(err, "failed to put tokens back to repository")
+		},
+	}, nil
+}
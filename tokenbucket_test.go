@@ -0,0 +1,239 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+	"github.com/yonasstephen/ratelimiter/repository/mocks"
+)
+
+func TestTokenBucketAllow(t *testing.T) {
+	testCases := []struct {
+		name             string
+		rate             int
+		duration         time.Duration
+		burst            int
+		requestIntervals []time.Duration
+		expectedResults  []*ratelimiter.Result
+	}{
+		{
+			name:             "burst within capacity",
+			rate:             1,
+			duration:         time.Second,
+			burst:            3,
+			requestIntervals: []time.Duration{0, 0, 0},
+			expectedResults: []*ratelimiter.Result{
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  2,
+					ResetAfter: time.Duration(1 * time.Second),
+				},
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  1,
+					ResetAfter: time.Duration(2 * time.Second),
+				},
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  0,
+					ResetAfter: time.Duration(3 * time.Second),
+				},
+			},
+		},
+		{
+			name:     "burst exceeds capacity and waits for refill",
+			rate:     2,
+			duration: time.Second,
+			burst:    3,
+			requestIntervals: []time.Duration{
+				0,
+				0,
+				0,
+				0,
+				300 * time.Millisecond,
+				200 * time.Millisecond,
+				2 * time.Second,
+			},
+			expectedResults: []*ratelimiter.Result{
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  2,
+					ResetAfter: time.Duration(500 * time.Millisecond),
+				},
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  1,
+					ResetAfter: time.Duration(1 * time.Second),
+				},
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  0,
+					ResetAfter: time.Duration(1500 * time.Millisecond),
+				},
+				// bucket is empty
+				{
+					Allowed:    0,
+					Limit:      3,
+					Remaining:  0,
+					RetryAfter: time.Duration(500 * time.Millisecond),
+					ResetAfter: time.Duration(1500 * time.Millisecond),
+				},
+				{
+					Allowed:    0,
+					Limit:      3,
+					Remaining:  0,
+					RetryAfter: time.Duration(200 * time.Millisecond),
+					ResetAfter: time.Duration(1200 * time.Millisecond),
+				},
+				// a token has been refilled
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  0,
+					ResetAfter: time.Duration(1500 * time.Millisecond),
+				},
+				// refill stops at capacity
+				{
+					Allowed:    1,
+					Limit:      3,
+					Remaining:  2,
+					ResetAfter: time.Duration(500 * time.Millisecond),
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClock := clock.NewMock()
+			r := ratelimiter.NewTokenBucketRateLimiter(tc.rate, tc.duration, tc.burst, repository.NewInMemRepository(), mockClock)
+
+			for i := 0; i < len(tc.expectedResults); i++ {
+				// move forward the time by specified interval
+				mockClock.Add(tc.requestIntervals[i])
+
+				res, err := r.Allow(context.Background(), "test_key")
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedResults[i], res, "request %d", i)
+			}
+		})
+	}
+}
+
+func TestTokenBucketAllow_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockTokenBucketRepository(ctrl)
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewTokenBucketRateLimiter(10, time.Second, 5, mockRepo, mockClock)
+
+	mockRepo.
+		EXPECT().
+		TakeTokens(gomock.Any(), gomock.Eq("test_key"), matchesTime(mockClock.Now()), gomock.Eq(100*time.Millisecond), gomock.Eq(5), gomock.Eq(1)).
+		Return(repository.TokenBucket{}, false, errors.New("unexpected repo error"))
+
+	res, err := r.Allow(context.Background(), "test_key")
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "failed to take token from repository: unexpected repo error")
+}
+
+func TestTokenBucketAllowN(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewTokenBucketRateLimiter(1, time.Second, 5, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.AllowN(ctx, "test_key", 4)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 4, Limit: 5, Remaining: 1, ResetAfter: 4 * time.Second}, res)
+
+	// 2 more tokens have to be refilled
+	res, err = r.AllowN(ctx, "test_key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{
+		Allowed:    0,
+		Limit:      5,
+		Remaining:  1,
+		RetryAfter: 2 * time.Second,
+		ResetAfter: 4 * time.Second,
+	}, res)
+
+	mockClock.Add(2 * time.Second)
+	res, err = r.AllowN(ctx, "test_key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 3, Limit: 5, Remaining: 0, ResetAfter: 5 * time.Second}, res)
+
+	// more than the capacity of the bucket
+	res, err = r.AllowN(ctx, "test_key", 6)
+	assert.Nil(t, res)
+	assert.Equal(t, ratelimiter.ErrExceedsLimit, err)
+}
+
+func TestTokenBucketStatus(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewTokenBucketRateLimiter(1, time.Second, 2, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	res, err := r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+
+	_, err = r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+
+	// status should not take a token
+	for i := 0; i < 2; i++ {
+		res, err = r.Status(ctx, "test_key")
+		assert.NoError(t, err)
+		assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 0, RetryAfter: time.Second, ResetAfter: 2 * time.Second}, res)
+	}
+
+	// bucket in debt
+	_, err = r.Reserve(ctx, "test_key", 1)
+	assert.NoError(t, err)
+	mockClock.Add(1500 * time.Millisecond)
+	res, err = r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 0, RetryAfter: 500 * time.Millisecond, ResetAfter: 1500 * time.Millisecond}, res)
+}
+
+func TestTokenBucketResetAndRefund(t *testing.T) {
+	mockClock := clock.NewMock()
+	r := ratelimiter.NewTokenBucketRateLimiter(1, time.Second, 2, repository.NewInMemRepository(), mockClock)
+	ctx := context.Background()
+
+	_, err := r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+
+	assert.NoError(t, r.Refund(ctx, "test_key", 1))
+	res, err := r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Remaining)
+
+	// refunds are capped at the capacity of the bucket
+	assert.NoError(t, r.Refund(ctx, "test_key", 5))
+	res, err = r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+
+	_, err = r.AllowN(ctx, "test_key", 2)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Reset(ctx, "test_key"))
+	res, err = r.Status(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.Equal(t, &ratelimiter.Result{Allowed: 0, Limit: 2, Remaining: 2}, res)
+}
@@ -0,0 +1,69 @@
+package ratelimiter
+
+import (
+	"context"
+
+	"github.com/benbjohnson/clock"
+	"github.com/pkg/errors"
+)
+
+// ErrWaitExceedsDeadline is returned by Waiter when the request would not
+// be allowed before the deadline of the context
+var ErrWaitExceedsDeadline = errors.New("wait would exceed context deadline")
+
+// Waiter blocks the caller until a request is allowed by the underlying
+// RateLimiter. It saves background jobs from looping on Allow and sleeping
+// on Result.RetryAfter themselves.
+type Waiter struct {
+	clock   clock.Clock
+	limiter RateLimiter
+}
+
+// NewWaiter returns a Waiter on top of the given limiter. The clock is
+// used to sleep between attempts, so it should be the same clock that is
+// used by the limiter.
+func NewWaiter(limiter RateLimiter, clock clock.Clock) *Waiter {
+	return &Waiter{
+		clock:   clock,
+		limiter: limiter,
+	}
+}
+
+// Wait is shorthand for WaitN(ctx, key, 1)
+func (w *Waiter) Wait(ctx context.Context, key string) error {
+	return w.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until n units of the given key are allowed. It returns
+// ctx.Err() if ctx is done while waiting, or ErrWaitExceedsDeadline right
+// away if the wait would go past the deadline of ctx. Errors of the
+// limiter, e.g. ErrExceedsLimit, are returned as is.
+func (w *Waiter) WaitN(ctx context.Context, key string, n int) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		res, err := w.limiter.AllowN(ctx, key, n)
+		if err != nil {
+			return err
+		}
+		if res.Allowed > 0 {
+			return nil
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && w.clock.Now().Add(res.RetryAfter).After(deadline) {
+			return ErrWaitExceedsDeadline
+		}
+
+		// the request may still be rejected after RetryAfter if other
+		// requests get in first, hence the loop
+		timer := w.clock.Timer(res.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
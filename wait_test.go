@@ -0,0 +1,125 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yonasstephen/ratelimiter"
+	"github.com/yonasstephen/ratelimiter/repository"
+)
+
+// deadlineCtx reports a deadline without ever being done by itself, so
+// that the deadline can be set relative to a mock clock
+type deadlineCtx struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c deadlineCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+// signalLimiter signals every AllowN of the wrapped rate limiter, so that
+// a test can tell when the Waiter has been rejected
+type signalLimiter struct {
+	ratelimiter.RateLimiter
+	calls chan struct{}
+}
+
+func newSignalLimiter(limiter ratelimiter.RateLimiter) *signalLimiter {
+	return &signalLimiter{RateLimiter: limiter, calls: make(chan struct{}, 10)}
+}
+
+func (l *signalLimiter) AllowN(ctx context.Context, key string, n int) (*ratelimiter.Result, error) {
+	res, err := l.RateLimiter.AllowN(ctx, key, n)
+	l.calls <- struct{}{}
+	return res, err
+}
+
+func TestWait(t *testing.T) {
+	mockClock := clock.NewMock()
+	limiter := newSignalLimiter(ratelimiter.NewFixedWindowRateLimiter(2, time.Second, repository.NewInMemRepository(), mockClock))
+	w := ratelimiter.NewWaiter(limiter, mockClock)
+	ctx := context.Background()
+
+	// within limit, should not block
+	require.NoError(t, w.Wait(ctx, "test_key"))
+	require.NoError(t, w.Wait(ctx, "test_key"))
+	<-limiter.calls
+	<-limiter.calls
+
+	// should block until the next window
+	done := make(chan error)
+	go func() {
+		done <- w.Wait(ctx, "test_key")
+	}()
+
+	<-limiter.calls
+	select {
+	case <-done:
+		t.Fatal("Wait returned before the request is allowed")
+	default:
+	}
+
+	// the clock is moved until the goroutine has started waiting on it
+	var err error
+	assert.Eventually(t, func() bool {
+		mockClock.Add(time.Second)
+		select {
+		case err = <-done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, err)
+}
+
+func TestWait_ContextCancelled(t *testing.T) {
+	mockClock := clock.NewMock()
+	limiter := newSignalLimiter(ratelimiter.NewFixedWindowRateLimiter(1, time.Second, repository.NewInMemRepository(), mockClock))
+	w := ratelimiter.NewWaiter(limiter, mockClock)
+	require.NoError(t, w.Wait(context.Background(), "test_key"))
+	<-limiter.calls
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Wait(ctx, "test_key")
+	}()
+
+	// cancelled once the request has been rejected
+	<-limiter.calls
+	cancel()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return on cancellation")
+	}
+
+	// already cancelled context should not consume the limit
+	assert.Equal(t, context.Canceled, w.Wait(ctx, "other_key"))
+	res, err := limiter.Allow(context.Background(), "other_key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Allowed)
+}
+
+func TestWaitN_ExceedsDeadline(t *testing.T) {
+	mockClock := clock.NewMock()
+	limiter := ratelimiter.NewTokenBucketRateLimiter(1, time.Second, 3, repository.NewInMemRepository(), mockClock)
+	w := ratelimiter.NewWaiter(limiter, mockClock)
+	require.NoError(t, w.WaitN(context.Background(), "test_key", 3))
+
+	// 2 tokens take 2 seconds to refill
+	ctx := deadlineCtx{Context: context.Background(), deadline: mockClock.Now().Add(time.Second)}
+	assert.Equal(t, ratelimiter.ErrWaitExceedsDeadline, w.WaitN(ctx, "test_key", 2))
+
+	// n can never be allowed
+	assert.Equal(t, ratelimiter.ErrExceedsLimit, w.WaitN(context.Background(), "test_key", 4))
+}